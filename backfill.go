@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+var (
+	backfillCommand      = kingpin.Command("backfill", "Write the workflow metrics of past workflow runs as OpenMetrics, to be imported with promtool tsdb create-blocks-from openmetrics.")
+	backfillRepositories = backfillCommand.Flag("repo", "Repository, as <owner>/<repo>, whose workflow runs are backfilled. Can be repeated.").Strings()
+	backfillOrgs         = backfillCommand.Flag("org", "GitHub Organization whose repositories' workflow runs are backfilled. Can be repeated.").Strings()
+	backfillFrom         = backfillCommand.Flag("from", "Start of the backfilled range, as a date (2006-01-02) or an RFC 3339 time.").Required().String()
+	backfillTo           = backfillCommand.Flag("to", "End of the backfilled range, as a date (2006-01-02) or an RFC 3339 time. Defaults to now.").String()
+	backfillStep         = backfillCommand.Flag("step", "Interval between two samples of the backfilled series.").Default("5m").Duration()
+	backfillOutput       = backfillCommand.Flag("output", "OpenMetrics file to write, - for the standard output.").Short('o').Default("-").String()
+)
+
+// runBackfill runs the backfill command and returns the exit code.
+func runBackfill(logger log.Logger) int {
+	from, err := parseBackfillTime(*backfillFrom)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Invalid --from", "err", err)
+		return 1
+	}
+	to := time.Now()
+	if *backfillTo != "" {
+		to, err = parseBackfillTime(*backfillTo)
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "Invalid --to", "err", err)
+			return 1
+		}
+	}
+
+	gitHubAppPrivateKey, gitHubCACertificates, err := readGitHubAPIFiles()
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to read the GitHub API configuration", "err", err)
+		return 1
+	}
+
+	opts := server.Opts{
+		WorkflowJobRunnerLabels:     *jobRunnerLabels,
+		WorkflowJobRunnerName:       *jobRunnerName,
+		StepNames:                   *stepNames,
+		StepNameRegex:               *stepNameRegex,
+		GitHubAPIToken:              *gitHubAPIToken,
+		GitHubAppID:                 *gitHubAppID,
+		GitHubAppPrivateKey:         gitHubAppPrivateKey,
+		GitHubAppInstallationID:     *gitHubAppInstallationID,
+		GitHubAPIURL:                *gitHubAPIURL,
+		GitHubUploadURL:             *gitHubUploadURL,
+		GitHubCACertificates:        gitHubCACertificates,
+		GitHubProxyURL:              *gitHubProxyURL,
+		GitHubAPIRateLimitThreshold: *gitHubAPIRateLimitThreshold,
+	}
+	clients, err := server.NewGitHubClients(logger, opts)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to create the GitHub API clients", "err", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *backfillOutput != "-" {
+		file, err := os.Create(*backfillOutput)
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "Unable to create the output file", "err", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	backfiller := server.NewBackfiller(logger, opts, clients)
+	if err := backfiller.Backfill(context.Background(), w, *backfillRepositories, *backfillOrgs, from, to, *backfillStep); err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to backfill the workflow metrics", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// parseBackfillTime parses a date or an RFC 3339 time.
+func parseBackfillTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a date (2006-01-02) or an RFC 3339 time: %w", err)
+	}
+	return t, nil
+}
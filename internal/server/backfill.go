@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// backfillRunsLimit is the number of workflow runs the GitHub API returns at most for a query
+// filtered by creation date, the runs are therefore listed one day at a time.
+const backfillRunsLimit = 1000
+
+// Backfiller collects the completed workflow runs and jobs of repositories over a time range
+// with the GitHub API, and writes the workflow metrics they would have produced as timestamped
+// OpenMetrics samples, e.g. for `promtool tsdb create-blocks-from openmetrics`.
+type Backfiller struct {
+	GHClients *GitHubClients
+	Logger    log.Logger
+	Opts      Opts
+}
+
+// backfillEvent is the completion of a workflow run or job.
+type backfillEvent struct {
+	at      time.Time
+	collect func(exporter *WorkflowMetricsExporter)
+}
+
+func NewBackfiller(logger log.Logger, opts Opts, clients *GitHubClients) *Backfiller {
+	return &Backfiller{
+		Logger:    logger,
+		Opts:      opts,
+		GHClients: clients,
+	}
+}
+
+// Backfill writes the workflow metrics of the workflow runs of the repositories, given as
+// <owner>/<repo>, and of every repository of the organizations, created between from and to.
+// The metrics are sampled every step, from the first completion onwards.
+func (b *Backfiller) Backfill(ctx context.Context, w io.Writer, repositories, orgs []string, from, to time.Time, step time.Duration) error {
+	if !b.GHClients.Enabled() {
+		return errors.New("github credentials not configured")
+	}
+	if step <= 0 {
+		return errors.New("backfill step not configured")
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("backfill range start %s is not before its end %s", from, to)
+	}
+
+	targets, err := b.targets(ctx, repositories, orgs)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("repository or org not configured")
+	}
+
+	var events []backfillEvent
+	for _, target := range targets {
+		targetEvents, err := b.listEvents(ctx, target, from, to)
+		if err != nil {
+			return fmt.Errorf("backfilling %s/%s: %w", target.owner, target.repo, err)
+		}
+		events = append(events, targetEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	_ = level.Info(b.Logger).Log("msg", "collected workflow run and job completions", "repositories", len(targets), "completions", len(events))
+
+	return b.writeMetrics(w, events, step)
+}
+
+// targets returns the repositories to backfill.
+func (b *Backfiller) targets(ctx context.Context, repositories, orgs []string) ([]reconcileTarget, error) {
+	var targets []reconcileTarget
+	for _, repository := range repositories {
+		owner, repo, found := strings.Cut(repository, "/")
+		if !found || owner == "" || repo == "" {
+			return nil, fmt.Errorf("invalid backfill repository %q, expected <owner>/<repo>", repository)
+		}
+		targets = append(targets, reconcileTarget{owner: owner, repo: repo})
+	}
+
+	for _, org := range orgs {
+		client, err := b.GHClients.ForOrg(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		repos, err := listPages(func(opts github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.ListByOrg(ctx, org, &github.RepositoryListByOrgOptions{ListOptions: opts})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing repositories of %s: %w", org, err)
+		}
+		for _, repo := range repos {
+			if repo.GetArchived() {
+				continue
+			}
+			targets = append(targets, reconcileTarget{owner: org, repo: repo.GetName()})
+		}
+	}
+
+	return targets, nil
+}
+
+// listEvents returns the completions of the workflow runs of a repository created within the
+// range, and of all their jobs.
+func (b *Backfiller) listEvents(ctx context.Context, target reconcileTarget, from, to time.Time) ([]backfillEvent, error) {
+	client, err := b.GHClients.ForRepository(ctx, target.owner, target.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// The creation date filter includes both ends of its range, the runs created at the bound of
+	// two days are listed twice.
+	seen := map[string]bool{}
+	var events []backfillEvent
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		end := day.Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+
+		var total int
+		runs, err := listPages(func(opts github.ListOptions) ([]*github.WorkflowRun, *github.Response, error) {
+			runs, res, err := client.Actions.ListRepositoryWorkflowRuns(ctx, target.owner, target.repo, &github.ListWorkflowRunsOptions{
+				Status:      "completed",
+				Created:     day.UTC().Format(time.RFC3339) + ".." + end.UTC().Format(time.RFC3339),
+				ListOptions: opts,
+			})
+			if runs == nil {
+				return nil, res, err
+			}
+			total = runs.GetTotalCount()
+			return runs.WorkflowRuns, res, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing workflow runs: %w", err)
+		}
+		if total > backfillRunsLimit {
+			_ = level.Warn(b.Logger).Log("msg", "too many workflow runs in a day, only some of them are backfilled", "org", target.owner, "repo", target.repo, "day", day.Format(time.DateOnly), "runs", total)
+		}
+
+		for _, run := range runs {
+			key := fmt.Sprintf("%d/%d", run.GetID(), run.GetRunAttempt())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			events = append(events, backfillEvent{at: run.GetUpdatedAt().Time, collect: func(exporter *WorkflowMetricsExporter) {
+				exporter.CollectWorkflowRunEvent(&github.WorkflowRunEvent{
+					Action:      github.String("completed"),
+					WorkflowRun: run,
+					Workflow:    &github.Workflow{ID: run.WorkflowID, Name: run.Name},
+					Repo:        run.GetRepository(),
+				})
+			}})
+
+			jobs, err := listPages(func(opts github.ListOptions) ([]*github.WorkflowJob, *github.Response, error) {
+				jobs, res, err := client.Actions.ListWorkflowJobs(ctx, target.owner, target.repo, run.GetID(), &github.ListWorkflowJobsOptions{Filter: "all", ListOptions: opts})
+				if jobs == nil {
+					return nil, res, err
+				}
+				return jobs.Jobs, res, err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("listing workflow jobs of run %d: %w", run.GetID(), err)
+			}
+			for _, job := range jobs {
+				if job.GetStatus() != "completed" || job.CompletedAt == nil {
+					continue
+				}
+				events = append(events, backfillEvent{at: job.GetCompletedAt().Time, collect: func(exporter *WorkflowMetricsExporter) {
+					exporter.CollectWorkflowJobEvent(&github.WorkflowJobEvent{
+						Action:      github.String("completed"),
+						WorkflowJob: job,
+						Repo:        run.GetRepository(),
+					})
+				}})
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// writeMetrics writes the samples of the events every step as OpenMetrics. The samples of a family
+// must be written together, and those of a series in time order: the events are replayed once per
+// family, so that only the samples of one family are held in memory at a time.
+func (b *Backfiller) writeMetrics(w io.Writer, events []backfillEvent, step time.Duration) error {
+	names := map[string]bool{}
+	err := b.replay(events, step, func(families []*dto.MetricFamily, _ time.Time) {
+		for _, family := range families {
+			names[family.GetName()] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		var samples *dto.MetricFamily
+		err := b.replay(events, step, func(families []*dto.MetricFamily, sampleAt time.Time) {
+			for _, family := range families {
+				if family.GetName() != name {
+					continue
+				}
+				if samples == nil {
+					samples = &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				}
+				for _, metric := range family.Metric {
+					metric.TimestampMs = proto.Int64(sampleAt.UnixMilli())
+					samples.Metric = append(samples.Metric, metric)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, openMetricsFamily(samples)); err != nil {
+			return err
+		}
+	}
+	_, err = expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// replay collects the events, sorted by time, into a fresh registry and passes its metric families
+// to sample every step, from the first event onwards.
+func (b *Backfiller) replay(events []backfillEvent, step time.Duration, sample func(families []*dto.MetricFamily, sampleAt time.Time)) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	reg := prometheus.NewRegistry()
+	exporter := &WorkflowMetricsExporter{
+		Logger:             b.Logger,
+		Opts:               b.Opts,
+		PrometheusObserver: NewPrometheusObserver(reg, b.Opts),
+	}
+
+	sampleAt := events[0].at.Truncate(step).Add(step)
+	for i := 0; i < len(events); sampleAt = sampleAt.Add(step) {
+		for ; i < len(events) && events[i].at.Before(sampleAt); i++ {
+			events[i].collect(exporter)
+		}
+
+		families, err := reg.Gather()
+		if err != nil {
+			return err
+		}
+		sample(families, sampleAt)
+	}
+	return nil
+}
+
+// openMetricsFamily groups the samples of each series of a family in time order. The counters
+// whose name doesn't end with _total are written as unknown, as OpenMetrics would otherwise add
+// the suffix and the backfilled series wouldn't match the scraped ones.
+func openMetricsFamily(family *dto.MetricFamily) *dto.MetricFamily {
+	sort.SliceStable(family.Metric, func(i, j int) bool {
+		return labelsKey(family.Metric[i]) < labelsKey(family.Metric[j])
+	})
+
+	if family.GetType() != dto.MetricType_COUNTER || strings.HasSuffix(family.GetName(), "_total") {
+		return family
+	}
+	family.Type = dto.MetricType_UNTYPED.Enum()
+	for _, metric := range family.Metric {
+		metric.Untyped = &dto.Untyped{Value: proto.Float64(metric.GetCounter().GetValue())}
+		metric.Counter = nil
+	}
+	return family
+}
+
+// labelsKey identifies the series of a metric by its label values, sorted by label name.
+func labelsKey(metric *dto.Metric) string {
+	var key strings.Builder
+	for _, label := range metric.Label {
+		key.WriteString(label.GetName())
+		key.WriteByte(0)
+		key.WriteString(label.GetValue())
+		key.WriteByte(0)
+	}
+	return key.String()
+}
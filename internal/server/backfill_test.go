@@ -0,0 +1,87 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Backfiller_WritesTimestampedOpenMetrics(t *testing.T) {
+	// Given
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	repository := &github.Repository{Name: github.String("backfill-repo"), Owner: &github.User{Login: github.String("backfill-org")}}
+	run := func(id int64, completedAt time.Time) *github.WorkflowRun {
+		return &github.WorkflowRun{
+			ID: github.Int64(id), Name: github.String("Build"), RunAttempt: github.Int(1),
+			HeadBranch: github.String("main"), Status: github.String("completed"), Conclusion: github.String("success"),
+			RunStartedAt: &github.Timestamp{Time: completedAt.Add(-time.Minute)}, UpdatedAt: &github.Timestamp{Time: completedAt},
+			Repository: repository,
+		}
+	}
+	listJobs := func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "all", r.URL.Query().Get("filter"))
+		_ = json.NewEncoder(w).Encode(github.Jobs{})
+	}
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/orgs/backfill-org/repos": respondJSON([]*github.Repository{repository, {Name: github.String("archived-repo"), Archived: github.Bool(true)}}),
+		"/repos/backfill-org/backfill-repo/actions/runs": func(w http.ResponseWriter, r *http.Request) {
+			// The windows of consecutive days share their bound, the runs created then are listed twice.
+			switch {
+			case strings.HasPrefix(r.URL.Query().Get("created"), "2024-03-01T00:00:00Z.."):
+				_ = json.NewEncoder(w).Encode(github.WorkflowRuns{WorkflowRuns: []*github.WorkflowRun{
+					run(1, from.Add(2*time.Minute)),
+					run(2, from.Add(11*time.Minute)),
+				}})
+			case strings.HasPrefix(r.URL.Query().Get("created"), "2024-03-02T00:00:00Z.."):
+				_ = json.NewEncoder(w).Encode(github.WorkflowRuns{WorkflowRuns: []*github.WorkflowRun{run(2, from.Add(11*time.Minute))}})
+			default:
+				_ = json.NewEncoder(w).Encode(github.WorkflowRuns{})
+			}
+		},
+		"/repos/backfill-org/backfill-repo/actions/runs/1/jobs": listJobs,
+		"/repos/backfill-org/backfill-repo/actions/runs/2/jobs": listJobs,
+	})
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	backfiller := server.NewBackfiller(log.NewNopLogger(), opts, clients)
+	out := &bytes.Buffer{}
+
+	// When
+	err = backfiller.Backfill(context.Background(), out, nil, []string{"backfill-org"}, from, from.Add(48*time.Hour), 5*time.Minute)
+
+	// Then
+	require.NoError(t, err)
+	expected := `# HELP workflow_status_count Count of the occurrences of different workflow states.
+# TYPE workflow_status_count unknown
+workflow_status_count{branch="main",conclusion="success",org="backfill-org",repo="backfill-repo",status="completed",workflow_name="Build"} 1.0 1.7092515e+09
+workflow_status_count{branch="main",conclusion="success",org="backfill-org",repo="backfill-repo",status="completed",workflow_name="Build"} 1.0 1.7092518e+09
+workflow_status_count{branch="main",conclusion="success",org="backfill-org",repo="backfill-repo",status="completed",workflow_name="Build"} 2.0 1.7092521e+09
+`
+	assert.Contains(t, out.String(), expected)
+	assert.Equal(t, 1, strings.Count(out.String(), "# TYPE workflow_status_count "))
+	assert.Equal(t, 1, strings.Count(out.String(), "# TYPE workflow_execution_time_seconds "))
+	assert.Contains(t, out.String(), `workflow_execution_time_seconds_count{branch="main",conclusion="success",org="backfill-org",repo="backfill-repo",workflow_name="Build"} 2 1.7092521e+09`)
+	assert.True(t, strings.HasSuffix(out.String(), "# EOF\n"))
+}
+
+func Test_Backfiller_InvalidRange(t *testing.T) {
+	opts := server.Opts{GitHubAPIToken: "some-token"}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	backfiller := server.NewBackfiller(log.NewNopLogger(), opts, clients)
+	now := time.Now()
+
+	err = backfiller.Backfill(context.Background(), &bytes.Buffer{}, []string{"some-org/some-repo"}, nil, now, now.Add(-time.Hour), time.Minute)
+
+	assert.Error(t, err)
+}
@@ -3,113 +3,148 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/go-github/v66/github"
-	"golang.org/x/oauth2"
 )
 
 type BillingMetricsExporter struct {
-	GHClient *github.Client
-	Logger   log.Logger
-	Opts     Opts
+	GHClients *GitHubClients
+	Logger    log.Logger
+	Opts      Opts
 }
 
-func NewBillingMetricsExporter(logger log.Logger, opts Opts) *BillingMetricsExporter {
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: opts.GitHubAPIToken},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-
+func NewBillingMetricsExporter(logger log.Logger, opts Opts, clients *GitHubClients) *BillingMetricsExporter {
 	return &BillingMetricsExporter{
-		Logger:   logger,
-		Opts:     opts,
-		GHClient: client,
+		Logger:    logger,
+		Opts:      opts,
+		GHClients: clients,
 	}
 }
 
-func (c *BillingMetricsExporter) StartOrgBilling(ctx context.Context) error {
-	if c.Opts.GitHubOrg == "" {
-		return errors.New("github org not configured")
+// StartBilling polls the actions billing of every configured account, each one on its own
+// schedule so that an account failing to be polled doesn't hold back the others.
+func (c *BillingMetricsExporter) StartBilling(ctx context.Context) error {
+	if len(c.Opts.GitHubAccounts) == 0 {
+		return errors.New("github org or user not configured")
 	}
-	if c.Opts.GitHubAPIToken == "" {
-		return errors.New("github token not configured")
+	if !c.GHClients.Enabled() {
+		return errors.New("github credentials not configured")
 	}
 
-	ticker := time.NewTicker(time.Duration(c.Opts.BillingAPIPollSeconds) * time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collectOrgBilling(ctx)
-			case <-ctx.Done():
-				_ = level.Info(c.Logger).Log("msg", "stopped polling for org billing metrics")
-				return
-			}
-		}
-	}()
+	for _, account := range c.Opts.GitHubAccounts {
+		go c.pollBilling(ctx, account)
+	}
 
 	return nil
 }
 
-func (c *BillingMetricsExporter) StartUserBilling(ctx context.Context) error {
-	if c.Opts.GitHubUser == "" {
-		return errors.New("github user not configured")
-	}
-	if c.Opts.GitHubAPIToken == "" {
-		return errors.New("github token not configured")
+func (c *BillingMetricsExporter) pollBilling(ctx context.Context, account GitHubAccount) {
+	interval := account.PollInterval
+	if interval <= 0 {
+		interval = time.Duration(c.Opts.BillingAPIPollSeconds) * time.Second
 	}
 
-	ticker := time.NewTicker(time.Duration(c.Opts.BillingAPIPollSeconds) * time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collectUserBilling(ctx)
-			case <-ctx.Done():
-				ticker.Stop()
-				_ = level.Info(c.Logger).Log("msg", "stopped polling for user billing metrics")
-				return
-			}
-		}
-	}()
+	poll(ctx, "billing", account.Kind+":"+account.Login, interval, func(ctx context.Context) error {
+		return c.collectBilling(ctx, account)
+	})
+	_ = level.Info(c.Logger).Log("msg", "stopped polling for billing metrics", account.Kind, account.Login)
+}
 
+// collectBilling collects the actions billing of an account, from its usage report when
+// enabled and otherwise, or when the account has no usage report, from the actions billing endpoint.
+func (c *BillingMetricsExporter) collectBilling(ctx context.Context, account GitHubAccount) error {
+	var err error
+	if c.Opts.BillingUsageReport {
+		err = c.collectUsageReport(ctx, account)
+		if isNotFound(err) {
+			_ = level.Debug(c.Logger).Log("msg", "no usage report, falling back to the actions billing", account.Kind, account.Login, "err", err)
+			err = c.collectActionsBilling(ctx, account)
+		}
+	} else {
+		err = c.collectActionsBilling(ctx, account)
+	}
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to retrieve the actions billing", account.Kind, account.Login, "err", err)
+		return err
+	}
 	return nil
 }
 
-// CollectActionBilling collect the action billing.
-func (c *BillingMetricsExporter) collectOrgBilling(ctx context.Context) {
-	actionsBilling, _, err := c.GHClient.Billing.GetActionsBillingOrg(ctx, c.Opts.GitHubOrg)
+// collectActionsBilling collects the actions billing of an account from the actions billing
+// endpoint, along with the breakdown per organization of enterprises that have a usage report.
+func (c *BillingMetricsExporter) collectActionsBilling(ctx context.Context, account GitHubAccount) error {
+	org, user, enterprise := c.Opts.accountLabels(account)
+
+	actionsBilling, err := c.getActionsBilling(ctx, account)
 	if err != nil {
-		_ = c.Logger.Log("msg", "failed to retrieve the actions billing for an org", "org", c.Opts.GitHubOrg, "err", err)
-		return
+		return err
 	}
 
-	totalMinutesUsedActions.WithLabelValues(c.Opts.GitHubOrg, "").Set(actionsBilling.TotalMinutesUsed)
-	includedMinutesUsedActions.WithLabelValues(c.Opts.GitHubOrg, "").Set(actionsBilling.IncludedMinutes)
-	totalPaidMinutesActions.WithLabelValues(c.Opts.GitHubOrg, "").Set(actionsBilling.TotalPaidMinutesUsed)
+	totalMinutesUsedActions.WithLabelValues(org, user, enterprise).Set(actionsBilling.TotalMinutesUsed)
+	includedMinutesUsedActions.WithLabelValues(org, user, enterprise).Set(actionsBilling.IncludedMinutes)
+	totalPaidMinutesActions.WithLabelValues(org, user, enterprise).Set(actionsBilling.TotalPaidMinutesUsed)
 
 	for host, minutes := range actionsBilling.MinutesUsedBreakdown {
-		totalMinutesUsedByHostTypeActions.WithLabelValues(c.Opts.GitHubOrg, "", host).Set(float64(minutes))
+		totalMinutesUsedByHostTypeActions.WithLabelValues(org, user, enterprise, host).Set(float64(minutes))
+	}
+
+	if account.Kind != GitHubAccountEnterprise {
+		return nil
 	}
-}
 
-func (c *BillingMetricsExporter) collectUserBilling(ctx context.Context) {
-	actionsBilling, _, err := c.GHClient.Billing.GetActionsBillingUser(ctx, c.Opts.GitHubUser)
+	items, err := c.getUsageReport(ctx, account)
+	if isNotFound(err) {
+		_ = level.Debug(c.Logger).Log("msg", "no usage report for the enterprise", "enterprise", account.Login, "err", err)
+		return nil
+	}
 	if err != nil {
-		_ = c.Logger.Log("msg", "failed to retrieve the actions billing for an user", "user", c.Opts.GitHubUser, "err", err)
-		return
+		return err
 	}
+	c.setEnterpriseUsageByOrg(enterprise, items)
 
-	totalMinutesUsedActions.WithLabelValues("", c.Opts.GitHubUser).Set(actionsBilling.TotalMinutesUsed)
-	includedMinutesUsedActions.WithLabelValues("", c.Opts.GitHubUser).Set(actionsBilling.IncludedMinutes)
-	totalPaidMinutesActions.WithLabelValues("", c.Opts.GitHubUser).Set(actionsBilling.TotalPaidMinutesUsed)
+	return nil
+}
 
-	for host, minutes := range actionsBilling.MinutesUsedBreakdown {
-		totalMinutesUsedByHostTypeActions.WithLabelValues("", c.Opts.GitHubUser, host).Set(float64(minutes))
+// client returns the client to access the GitHub API on behalf of an account.
+func (c *BillingMetricsExporter) client(ctx context.Context, account GitHubAccount) (*github.Client, error) {
+	switch account.Kind {
+	case GitHubAccountOrg:
+		return c.GHClients.ForOrg(ctx, account.Login)
+	case GitHubAccountUser:
+		return c.GHClients.ForUser(ctx, account.Login)
+	case GitHubAccountEnterprise:
+		return c.GHClients.ForEnterprise(ctx, account.Login)
+	default:
+		return nil, fmt.Errorf("unknown github account kind %q", account.Kind)
+	}
+}
+
+func (c *BillingMetricsExporter) getActionsBilling(ctx context.Context, account GitHubAccount) (*github.ActionBilling, error) {
+	client, err := c.client(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	switch account.Kind {
+	case GitHubAccountOrg:
+		actionsBilling, _, err := client.Billing.GetActionsBillingOrg(ctx, account.Login)
+		return actionsBilling, err
+	case GitHubAccountUser:
+		actionsBilling, _, err := client.Billing.GetActionsBillingUser(ctx, account.Login)
+		return actionsBilling, err
+	default:
+		req, err := client.NewRequest("GET", fmt.Sprintf("enterprises/%v/settings/billing/actions", account.Login), nil)
+		if err != nil {
+			return nil, err
+		}
+		actionsBilling := &github.ActionBilling{}
+		if _, err := client.Do(ctx, req, actionsBilling); err != nil {
+			return nil, err
+		}
+		return actionsBilling, nil
 	}
 }
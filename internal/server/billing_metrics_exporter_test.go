@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BillingMetricsExporter_CollectBillingOfSeveralAccounts(t *testing.T) {
+	// Given
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/orgs/billing-org-a/settings/billing/actions": respondJSON(map[string]interface{}{"total_minutes_used": 10, "included_minutes": 3000}),
+		"/orgs/billing-org-b/settings/billing/actions": respondStatus(http.StatusForbidden),
+		"/users/billing-user/settings/billing/actions": respondJSON(map[string]interface{}{"total_minutes_used": 20}),
+	})
+	opts.GitHubAccounts = []server.GitHubAccount{
+		{Kind: server.GitHubAccountOrg, Login: "billing-org-a"},
+		{Kind: server.GitHubAccountOrg, Login: "billing-org-b"},
+		{Kind: server.GitHubAccountUser, Login: "billing-user"},
+	}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewBillingMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), opts, clients)
+	orgA, _, _ := opts.AccountLabels(opts.GitHubAccounts[0])
+	orgB, _, _ := opts.AccountLabels(opts.GitHubAccounts[1])
+	_, user, _ := opts.AccountLabels(opts.GitHubAccounts[2])
+
+	// When
+	errs := map[string]error{}
+	for _, account := range opts.GitHubAccounts {
+		errs[account.Login] = exporter.CollectBilling(context.Background(), account)
+	}
+
+	// Then the accounts that could be polled are exported despite the failing one
+	assert.Equal(t, 10.0, testutil.ToFloat64(server.TotalMinutesUsedActions.WithLabelValues(orgA, "", "")))
+	assert.Equal(t, 3000.0, testutil.ToFloat64(server.IncludedMinutesUsedActions.WithLabelValues(orgA, "", "")))
+	assert.Equal(t, 20.0, testutil.ToFloat64(server.TotalMinutesUsedActions.WithLabelValues("", user, "")))
+	assert.NoError(t, errs["billing-org-a"])
+	assert.Error(t, errs["billing-org-b"])
+	assert.NoError(t, errs["billing-user"])
+	assert.Zero(t, testutil.ToFloat64(server.TotalMinutesUsedActions.WithLabelValues(orgB, "", "")))
+}
+
+func Test_BillingMetricsExporter_CollectEnterpriseBilling(t *testing.T) {
+	// Given
+	var authorization string
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/enterprises/some-enterprise/settings/billing/actions": func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"total_minutes_used":      300,
+				"total_paid_minutes_used": 100,
+				"minutes_used_breakdown":  map[string]int{"UBUNTU": 200, "MACOS": 100},
+			})
+		},
+		"/enterprises/some-enterprise/settings/billing/usage": func(w http.ResponseWriter, r *http.Request) {
+			assert.NotEmpty(t, r.URL.Query().Get("year"))
+			assert.NotEmpty(t, r.URL.Query().Get("month"))
+			_ = json.NewEncoder(w).Encode(server.UsageReport{UsageItems: []server.UsageItem{
+				{Product: "actions", SKU: "Actions Linux", UnitType: "minutes", Quantity: 120, NetAmount: 0.96, OrganizationName: "org-a"},
+				{Product: "actions", SKU: "Actions macOS", UnitType: "minutes", Quantity: 30, NetAmount: 2.4, OrganizationName: "org-a"},
+				{Product: "actions", SKU: "Actions storage", UnitType: "GigabyteHours", Quantity: 5, NetAmount: 0.5, OrganizationName: "org-b"},
+				{Product: "packages", SKU: "Packages storage", UnitType: "GigabyteHours", Quantity: 5, NetAmount: 1, OrganizationName: "org-b"},
+			}})
+		},
+	})
+	opts.GitHubEnterpriseToken = "enterprise-token"
+	account := server.GitHubAccount{Kind: server.GitHubAccountEnterprise, Login: "some-enterprise"}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewBillingMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), opts, clients)
+	_, _, enterprise := opts.AccountLabels(account)
+	orgA, orgB := opts.OwnerLabel("org-a"), opts.OwnerLabel("org-b")
+
+	// When
+	err = exporter.CollectBilling(context.Background(), account)
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer enterprise-token", authorization)
+	assert.Equal(t, 300.0, testutil.ToFloat64(server.TotalMinutesUsedActions.WithLabelValues("", "", enterprise)))
+	assert.Equal(t, 100.0, testutil.ToFloat64(server.TotalPaidMinutesActions.WithLabelValues("", "", enterprise)))
+	assert.Equal(t, 200.0, testutil.ToFloat64(server.TotalMinutesUsedByHostTypeActions.WithLabelValues("", "", enterprise, "UBUNTU")))
+	assert.Equal(t, 150.0, testutil.ToFloat64(server.TotalMinutesUsedByOrgActions.WithLabelValues(enterprise, orgA)))
+	assert.InDelta(t, 3.36, testutil.ToFloat64(server.NetAmountByOrgActions.WithLabelValues(enterprise, orgA)), 0.001)
+	assert.Equal(t, 0.0, testutil.ToFloat64(server.TotalMinutesUsedByOrgActions.WithLabelValues(enterprise, orgB)))
+	assert.Equal(t, 0.5, testutil.ToFloat64(server.NetAmountByOrgActions.WithLabelValues(enterprise, orgB)))
+}
+
+func Test_BillingMetricsExporter_StartBillingWithoutAccounts(t *testing.T) {
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token"})
+	require.NoError(t, err)
+	exporter := server.NewBillingMetricsExporter(log.NewNopLogger(), server.Opts{}, clients)
+
+	assert.Error(t, exporter.StartBilling(context.Background()))
+}
+
+func Test_BillingMetricsExporter_CollectUsageReport(t *testing.T) {
+	// Given
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/organizations/usage-org/settings/billing/usage": respondJSON(server.UsageReport{UsageItems: []server.UsageItem{
+			{Date: "2024-11-01", Product: "actions", SKU: "Actions Linux", UnitType: "minutes", Quantity: 100, GrossAmount: 0.8, NetAmount: 0.8, RepositoryName: "repo-a"},
+			{Date: "2024-11-02", Product: "actions", SKU: "Actions Linux", UnitType: "minutes", Quantity: 50, GrossAmount: 0.4, NetAmount: 0, RepositoryName: "repo-a"},
+			{Date: "2024-11-02", Product: "actions", SKU: "Actions storage", UnitType: "GigabyteHours", Quantity: 10, GrossAmount: 0.1, NetAmount: 0.1, RepositoryName: "repo-b"},
+			{Date: "2024-11-02", Product: "packages", SKU: "Packages storage", UnitType: "GigabyteHours", Quantity: 10, GrossAmount: 1, NetAmount: 1, RepositoryName: "repo-b"},
+		}}),
+		"/orgs/legacy-org/settings/billing/actions": respondJSON(map[string]interface{}{"total_minutes_used": 42}),
+	})
+	opts.BillingUsageReport = true
+	usageAccount := server.GitHubAccount{Kind: server.GitHubAccountOrg, Login: "usage-org"}
+	legacyAccount := server.GitHubAccount{Kind: server.GitHubAccountOrg, Login: "legacy-org"}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewBillingMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), opts, clients)
+	usageOrg, _, _ := opts.AccountLabels(usageAccount)
+	legacyOrg, _, _ := opts.AccountLabels(legacyAccount)
+	server.UsageMinutesActions.WithLabelValues(usageOrg, "", "", "repo-of-last-month", "Actions Linux").Set(1)
+
+	// When
+	usageErr := exporter.CollectBilling(context.Background(), usageAccount)
+	legacyErr := exporter.CollectBilling(context.Background(), legacyAccount)
+
+	// Then the usage is summed per repository and SKU
+	require.NoError(t, usageErr)
+	assert.Equal(t, 150.0, testutil.ToFloat64(server.UsageMinutesActions.WithLabelValues(usageOrg, "", "", "repo-a", "Actions Linux")))
+	assert.InDelta(t, 1.2, testutil.ToFloat64(server.UsageGrossAmountActions.WithLabelValues(usageOrg, "", "", "repo-a", "Actions Linux")), 0.001)
+	assert.InDelta(t, 0.8, testutil.ToFloat64(server.UsageNetAmountActions.WithLabelValues(usageOrg, "", "", "repo-a", "Actions Linux")), 0.001)
+	assert.Equal(t, 0.0, testutil.ToFloat64(server.UsageMinutesActions.WithLabelValues(usageOrg, "", "", "repo-b", "Actions storage")))
+	assert.InDelta(t, 0.1, testutil.ToFloat64(server.UsageNetAmountActions.WithLabelValues(usageOrg, "", "", "repo-b", "Actions storage")), 0.001)
+	assert.Equal(t, 2, testutil.CollectAndCount(server.UsageMinutesActions), "the usage of the previous month is dropped")
+
+	// Then the account without usage report falls back to the actions billing
+	assert.Equal(t, 42.0, testutil.ToFloat64(server.TotalMinutesUsedActions.WithLabelValues(legacyOrg, "", "")))
+	assert.NoError(t, legacyErr)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Product of the usage items of GitHub Actions in the usage reports of the enhanced billing platform.
+const usageProductActions = "actions"
+
+// usageReport is the response of the usage report endpoints of the enhanced billing platform,
+// which go-github doesn't support.
+type usageReport struct {
+	UsageItems []usageItem `json:"usageItems"`
+}
+
+// usageItem is a line item of a usage report.
+type usageItem struct {
+	Date             string  `json:"date"`
+	Product          string  `json:"product"`
+	SKU              string  `json:"sku"`
+	Quantity         float64 `json:"quantity"`
+	UnitType         string  `json:"unitType"`
+	PricePerUnit     float64 `json:"pricePerUnit"`
+	GrossAmount      float64 `json:"grossAmount"`
+	DiscountAmount   float64 `json:"discountAmount"`
+	NetAmount        float64 `json:"netAmount"`
+	OrganizationName string  `json:"organizationName"`
+	RepositoryName   string  `json:"repositoryName"`
+}
+
+// isActions reports whether the item accounts for GitHub Actions.
+func (i usageItem) isActions() bool {
+	return strings.EqualFold(i.Product, usageProductActions)
+}
+
+// isActionsMinutes reports whether the item accounts for GitHub Actions minutes.
+func (i usageItem) isActionsMinutes() bool {
+	return i.isActions() && strings.EqualFold(i.UnitType, "minutes")
+}
+
+// isNotFound reports whether err is the GitHub API answering that the requested resource
+// doesn't exist (anymore), e.g. the usage report of an account that isn't on the enhanced
+// billing platform.
+func isNotFound(err error) bool {
+	var errorResponse *github.ErrorResponse
+	if !errors.As(err, &errorResponse) || errorResponse.Response == nil {
+		return false
+	}
+	return errorResponse.Response.StatusCode == http.StatusNotFound || errorResponse.Response.StatusCode == http.StatusGone
+}
+
+// getUsageReport returns the usage items of an account for the current month.
+func (c *BillingMetricsExporter) getUsageReport(ctx context.Context, account GitHubAccount) ([]usageItem, error) {
+	client, err := c.client(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	switch account.Kind {
+	case GitHubAccountOrg:
+		path = fmt.Sprintf("organizations/%v/settings/billing/usage", account.Login)
+	case GitHubAccountUser:
+		path = fmt.Sprintf("users/%v/settings/billing/usage", account.Login)
+	default:
+		path = fmt.Sprintf("enterprises/%v/settings/billing/usage", account.Login)
+	}
+
+	now := time.Now()
+	query := url.Values{}
+	query.Set("year", strconv.Itoa(now.Year()))
+	query.Set("month", strconv.Itoa(int(now.Month())))
+
+	req, err := client.NewRequest("GET", path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &usageReport{}
+	if _, err := client.Do(ctx, req, report); err != nil {
+		return nil, fmt.Errorf("getting usage report: %w", err)
+	}
+
+	return report.UsageItems, nil
+}
+
+// usageKey identifies the usage of a SKU by a repository.
+type usageKey struct {
+	org, repo, sku string
+}
+
+// usage sums the usage items of a SKU by a repository over the month.
+type usage struct {
+	minutes, grossAmount, netAmount float64
+}
+
+// collectUsageReport collects the actions usage of an account per repository and SKU from its
+// usage report.
+func (c *BillingMetricsExporter) collectUsageReport(ctx context.Context, account GitHubAccount) error {
+	items, err := c.getUsageReport(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	org, user, enterprise := c.Opts.accountLabels(account)
+	usages := map[usageKey]*usage{}
+	for _, item := range items {
+		if !item.isActions() {
+			continue
+		}
+
+		key := usageKey{org: org, repo: item.RepositoryName, sku: item.SKU}
+		if account.Kind == GitHubAccountEnterprise {
+			key.org = c.Opts.ownerLabel(item.OrganizationName)
+		}
+		if usages[key] == nil {
+			usages[key] = &usage{}
+		}
+		if item.isActionsMinutes() {
+			usages[key].minutes += item.Quantity
+		}
+		usages[key].grossAmount += item.GrossAmount
+		usages[key].netAmount += item.NetAmount
+	}
+
+	// The usage is reported for the current month, the repositories and SKUs of the previous
+	// month are dropped.
+	accountLabels := prometheus.Labels{"user": user, "enterprise": enterprise}
+	if account.Kind != GitHubAccountEnterprise {
+		accountLabels["org"] = org
+	}
+	usageMinutesActions.DeletePartialMatch(accountLabels)
+	usageGrossAmountActions.DeletePartialMatch(accountLabels)
+	usageNetAmountActions.DeletePartialMatch(accountLabels)
+
+	for key, usage := range usages {
+		usageMinutesActions.WithLabelValues(key.org, user, enterprise, key.repo, key.sku).Set(usage.minutes)
+		usageGrossAmountActions.WithLabelValues(key.org, user, enterprise, key.repo, key.sku).Set(usage.grossAmount)
+		usageNetAmountActions.WithLabelValues(key.org, user, enterprise, key.repo, key.sku).Set(usage.netAmount)
+	}
+
+	if account.Kind == GitHubAccountEnterprise {
+		c.setEnterpriseUsageByOrg(enterprise, items)
+	}
+
+	return nil
+}
+
+// setEnterpriseUsageByOrg sets the actions minutes and net amount of each organization of an
+// enterprise from its usage items.
+func (c *BillingMetricsExporter) setEnterpriseUsageByOrg(enterprise string, items []usageItem) {
+	minutes := map[string]float64{}
+	netAmounts := map[string]float64{}
+	for _, item := range items {
+		if !item.isActions() || item.OrganizationName == "" {
+			continue
+		}
+		org := c.Opts.ownerLabel(item.OrganizationName)
+		if item.isActionsMinutes() {
+			minutes[org] += item.Quantity
+		}
+		netAmounts[org] += item.NetAmount
+	}
+
+	for org, amount := range netAmounts {
+		totalMinutesUsedByOrgActions.WithLabelValues(enterprise, org).Set(minutes[org])
+		netAmountByOrgActions.WithLabelValues(enterprise, org).Set(amount)
+	}
+}
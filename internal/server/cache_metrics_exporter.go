@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxCacheLargestKeys is the largest page of caches the GitHub API returns.
+const maxCacheLargestKeys = 100
+
+// CacheMetricsExporter polls the GitHub Actions cache usage of the configured organizations and
+// of their repositories.
+type CacheMetricsExporter struct {
+	GHClients *GitHubClients
+	Logger    log.Logger
+	Opts      Opts
+}
+
+func NewCacheMetricsExporter(logger log.Logger, opts Opts, clients *GitHubClients) *CacheMetricsExporter {
+	return &CacheMetricsExporter{
+		Logger:    logger,
+		Opts:      opts,
+		GHClients: clients,
+	}
+}
+
+// StartCache polls the cache usage of every configured organization, each one on its own.
+func (c *CacheMetricsExporter) StartCache(ctx context.Context) error {
+	if c.Opts.CachePollInterval <= 0 {
+		return errors.New("cache poll interval not configured")
+	}
+	if !c.GHClients.Enabled() {
+		return errors.New("github credentials not configured")
+	}
+
+	var orgs []string
+	for _, account := range c.Opts.GitHubAccounts {
+		if account.Kind == GitHubAccountOrg {
+			orgs = append(orgs, account.Login)
+		}
+	}
+	if len(orgs) == 0 {
+		return errors.New("github org not configured")
+	}
+	if c.Opts.CacheLargestKeys > maxCacheLargestKeys {
+		_ = level.Warn(c.Logger).Log("msg", "exporting the largest cache keys of each repository up to the maximum", "configured", c.Opts.CacheLargestKeys, "maximum", maxCacheLargestKeys)
+	}
+
+	for _, org := range orgs {
+		go func(org string) {
+			poll(ctx, "cache", GitHubAccountOrg+":"+org, c.Opts.CachePollInterval, func(ctx context.Context) error {
+				return c.collectCacheUsage(ctx, org)
+			})
+			_ = level.Info(c.Logger).Log("msg", "stopped polling for cache metrics", "org", org)
+		}(org)
+	}
+
+	return nil
+}
+
+// collectCacheUsage collects the cache usage of an organization and of each of its repositories
+// with active caches, along with the largest cache keys of these repositories when enabled.
+func (c *CacheMetricsExporter) collectCacheUsage(ctx context.Context, org string) error {
+	client, err := c.GHClients.ForOrg(ctx, org)
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to retrieve the cache usage", "org", org, "err", err)
+		return err
+	}
+
+	total, _, err := client.Actions.GetTotalCacheUsageForOrg(ctx, org)
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to retrieve the cache usage", "org", org, "err", err)
+		return fmt.Errorf("getting cache usage: %w", err)
+	}
+
+	repos, err := listPages(func(opts github.ListOptions) ([]*github.ActionsCacheUsage, *github.Response, error) {
+		usages, res, err := client.Actions.ListCacheUsageByRepoForOrg(ctx, org, &opts)
+		if usages == nil {
+			return nil, res, err
+		}
+		return usages.RepoCacheUsage, res, err
+	})
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to list the cache usage of the repositories", "org", org, "err", err)
+		return fmt.Errorf("listing cache usage by repository: %w", err)
+	}
+
+	orgLabel := c.Opts.ownerLabel(org)
+	cacheOrgCountGauge.WithLabelValues(orgLabel).Set(float64(total.TotalActiveCachesCount))
+	cacheOrgSizeGauge.WithLabelValues(orgLabel).Set(float64(total.TotalActiveCachesUsageSizeInBytes))
+
+	// Repositories whose caches were all evicted are no longer listed, their usage is dropped.
+	orgLabels := prometheus.Labels{"org": orgLabel}
+	cacheRepoCountGauge.DeletePartialMatch(orgLabels)
+	cacheRepoSizeGauge.DeletePartialMatch(orgLabels)
+	cacheKeySizeGauge.DeletePartialMatch(orgLabels)
+
+	for _, usage := range repos {
+		repo := usage.FullName
+		if _, name, found := strings.Cut(usage.FullName, "/"); found {
+			repo = name
+		}
+		cacheRepoCountGauge.WithLabelValues(orgLabel, repo).Set(float64(usage.ActiveCachesCount))
+		cacheRepoSizeGauge.WithLabelValues(orgLabel, repo).Set(float64(usage.ActiveCachesSizeInBytes))
+
+		if c.Opts.CacheLargestKeys <= 0 || usage.ActiveCachesCount == 0 {
+			continue
+		}
+		// A repository whose caches can't be listed doesn't hold back the other ones.
+		if err := c.collectLargestCaches(ctx, client, org, repo); err != nil {
+			_ = c.Logger.Log("msg", "failed to list the largest caches", "org", org, "repo", repo, "err", err)
+			pollerErrorsCounter.WithLabelValues("cache", "repo:"+org+"/"+repo).Inc()
+			continue
+		}
+	}
+
+	return nil
+}
+
+// collectLargestCaches collects the size of the largest caches of a repository.
+func (c *CacheMetricsExporter) collectLargestCaches(ctx context.Context, client *github.Client, org, repo string) error {
+	caches, _, err := client.Actions.ListCaches(ctx, org, repo, &github.ActionsCacheListOptions{
+		ListOptions: github.ListOptions{PerPage: min(c.Opts.CacheLargestKeys, maxCacheLargestKeys)},
+		Sort:        github.String("size_in_bytes"),
+		Direction:   github.String("desc"),
+	})
+	if err != nil {
+		return fmt.Errorf("listing caches: %w", err)
+	}
+
+	// Several versions of a key can be cached for a ref, their sizes add up.
+	orgLabel := c.Opts.ownerLabel(org)
+	for _, cache := range caches.ActionsCaches {
+		cacheKeySizeGauge.WithLabelValues(orgLabel, repo, cache.GetKey(), cache.GetRef()).Add(float64(cache.GetSizeInBytes()))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CacheMetricsExporter_CollectCacheUsage(t *testing.T) {
+	// Given
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/orgs/cache-org/actions/cache/usage": respondJSON(github.TotalCacheUsage{TotalActiveCachesCount: 3, TotalActiveCachesUsageSizeInBytes: 3000}),
+		"/orgs/cache-org/actions/cache/usage-by-repository": respondJSON(github.ActionsCacheUsageList{TotalCount: 2, RepoCacheUsage: []*github.ActionsCacheUsage{
+			{FullName: "cache-org/some-repo", ActiveCachesCount: 3, ActiveCachesSizeInBytes: 3000},
+			{FullName: "cache-org/empty-repo"},
+		}}),
+		"/repos/cache-org/some-repo/actions/caches": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "size_in_bytes", r.URL.Query().Get("sort"))
+			assert.Equal(t, "2", r.URL.Query().Get("per_page"))
+			_ = json.NewEncoder(w).Encode(github.ActionsCacheList{TotalCount: 3, ActionsCaches: []*github.ActionsCache{
+				{Key: github.String("go-mod"), Ref: github.String("refs/heads/main"), SizeInBytes: github.Int64(2000)},
+				{Key: github.String("node"), Ref: github.String("refs/heads/main"), SizeInBytes: github.Int64(500)},
+			}})
+		},
+	})
+	opts.GitHubAccounts = []server.GitHubAccount{{Kind: server.GitHubAccountOrg, Login: "cache-org"}}
+	opts.CacheLargestKeys = 2
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewCacheMetricsExporter(log.NewNopLogger(), opts, clients)
+	org := opts.OwnerLabel("cache-org")
+	server.CacheRepoSizeGauge.WithLabelValues(org, "evicted-repo").Set(100)
+
+	// When
+	err = exporter.CollectCacheUsage(context.Background(), "cache-org")
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, testutil.ToFloat64(server.CacheOrgCountGauge.WithLabelValues(org)))
+	assert.Equal(t, 3000.0, testutil.ToFloat64(server.CacheOrgSizeGauge.WithLabelValues(org)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(server.CacheRepoCountGauge.WithLabelValues(org, "some-repo")))
+	assert.Equal(t, 3000.0, testutil.ToFloat64(server.CacheRepoSizeGauge.WithLabelValues(org, "some-repo")))
+	assert.Equal(t, 2, testutil.CollectAndCount(server.CacheRepoSizeGauge), "evicted repositories are dropped")
+	assert.Equal(t, 2000.0, testutil.ToFloat64(server.CacheKeySizeGauge.WithLabelValues(org, "some-repo", "go-mod", "refs/heads/main")))
+	assert.Equal(t, 2, testutil.CollectAndCount(server.CacheKeySizeGauge))
+}
+
+func Test_CacheMetricsExporter_CollectCacheUsageWhenARepositoryFails(t *testing.T) {
+	// Given
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/orgs/flaky-org/actions/cache/usage": respondJSON(github.TotalCacheUsage{TotalActiveCachesCount: 2, TotalActiveCachesUsageSizeInBytes: 2000}),
+		"/orgs/flaky-org/actions/cache/usage-by-repository": respondJSON(github.ActionsCacheUsageList{TotalCount: 2, RepoCacheUsage: []*github.ActionsCacheUsage{
+			{FullName: "flaky-org/forbidden-repo", ActiveCachesCount: 1, ActiveCachesSizeInBytes: 1000},
+			{FullName: "flaky-org/some-repo", ActiveCachesCount: 1, ActiveCachesSizeInBytes: 1000},
+		}}),
+		"/repos/flaky-org/forbidden-repo/actions/caches": respondStatus(http.StatusForbidden),
+		"/repos/flaky-org/some-repo/actions/caches": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "100", r.URL.Query().Get("per_page"))
+			_ = json.NewEncoder(w).Encode(github.ActionsCacheList{TotalCount: 1, ActionsCaches: []*github.ActionsCache{
+				{Key: github.String("go-mod"), Ref: github.String("refs/heads/main"), SizeInBytes: github.Int64(1000)},
+			}})
+		},
+	})
+	opts.GitHubAccounts = []server.GitHubAccount{{Kind: server.GitHubAccountOrg, Login: "flaky-org"}}
+	opts.CacheLargestKeys = 500
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewCacheMetricsExporter(log.NewNopLogger(), opts, clients)
+	org := opts.OwnerLabel("flaky-org")
+
+	// When
+	err = exporter.CollectCacheUsage(context.Background(), "flaky-org")
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, testutil.ToFloat64(server.CacheRepoSizeGauge.WithLabelValues(org, "some-repo")))
+	assert.Equal(t, 1000.0, testutil.ToFloat64(server.CacheKeySizeGauge.WithLabelValues(org, "some-repo", "go-mod", "refs/heads/main")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.PollerErrorsCounter.WithLabelValues("cache", "repo:flaky-org/forbidden-repo")))
+}
+
+func Test_CacheMetricsExporter_StartCacheWithoutOrg(t *testing.T) {
+	opts := server.Opts{
+		GitHubAPIToken:    "some-token",
+		GitHubAccounts:    []server.GitHubAccount{{Kind: server.GitHubAccountUser, Login: "someone"}},
+		CachePollInterval: 1,
+	}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewCacheMetricsExporter(log.NewNopLogger(), opts, clients)
+
+	err = exporter.StartCache(context.Background())
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxDeliverySize is the maximum size of a captured delivery read from a JSONL file, GitHub
+// caps the webhook payloads at 25 MB.
+const maxDeliverySize = 32 << 20
+
+// Delivery is a captured webhook delivery: its headers and its raw body.
+type Delivery struct {
+	// ID is the X-GitHub-Delivery GUID, used when the headers don't have it.
+	ID string `json:"delivery_id,omitempty"`
+	// Event is the X-GitHub-Event type, used when the headers don't have it.
+	Event   string            `json:"event,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the payload, either as JSON or as a JSON string holding the raw payload.
+	Body json.RawMessage `json:"body"`
+}
+
+// Header returns the value of a header of the delivery.
+func (d Delivery) Header(name string) string {
+	for key, value := range d.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case "X-Github-Event":
+		return d.Event
+	case "X-Github-Delivery":
+		return d.ID
+	}
+	return ""
+}
+
+// Payload returns the raw payload of the delivery.
+func (d Delivery) Payload() ([]byte, error) {
+	body := bytes.TrimSpace(d.Body)
+	if len(body) == 0 || body[0] != '"' {
+		return body, nil
+	}
+	var payload string
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	return []byte(payload), nil
+}
+
+// request returns the delivery as a webhook request to url, signed with secret. The signatures
+// of the captured headers are dropped.
+func (d Delivery) request(url, secret string) (*http.Request, error) {
+	payload, err := d.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("reading the payload of delivery %s: %w", d.Header("X-GitHub-Delivery"), err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range d.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("X-GitHub-Event", d.Header("X-GitHub-Event"))
+	req.Header.Set("X-GitHub-Delivery", d.Header("X-GitHub-Delivery"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("X-Hub-Signature")
+	req.Header.Set("X-Hub-Signature-256", signWebhookPayload(secret, payload))
+	return req, nil
+}
+
+// signWebhookPayload returns the X-Hub-Signature-256 header of a payload signed with secret.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadDeliveries reads the captured deliveries of a JSONL file, one delivery per line, or of a
+// directory holding such files and JSON files of one delivery each, in the order of their names.
+func ReadDeliveries(path string) ([]Delivery, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readDeliveriesFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".json" || ext == ".jsonl") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var deliveries []Delivery
+	for _, name := range names {
+		fileDeliveries, err := readDeliveriesFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, fileDeliveries...)
+	}
+	return deliveries, nil
+}
+
+func readDeliveriesFile(path string) ([]Delivery, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".json" {
+		var delivery Delivery
+		if err := json.NewDecoder(file).Decode(&delivery); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return []Delivery{delivery}, nil
+	}
+	return decodeDeliveries(path, file)
+}
+
+// decodeDeliveries decodes the deliveries of a JSONL stream, skipping empty lines.
+func decodeDeliveries(name string, r io.Reader) ([]Delivery, error) {
+	var deliveries []Delivery
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxDeliverySize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var delivery Delivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			return nil, fmt.Errorf("reading %s:%d: %w", name, line, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return deliveries, nil
+}
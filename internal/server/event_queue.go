@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// eventQueue processes webhook events with a fixed number of workers reading from a bounded queue.
+type eventQueue struct {
+	logger  log.Logger
+	metrics *deliveryMetrics
+	events  chan queuedEvent
+	wg      sync.WaitGroup
+
+	// mu guards closed, so that no event is sent once the events channel is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+type queuedEvent struct {
+	eventType  string
+	enqueuedAt time.Time
+	process    func()
+}
+
+func newEventQueue(logger log.Logger, size, workers int, metrics *deliveryMetrics) *eventQueue {
+	q := &eventQueue{
+		logger:  logger,
+		metrics: metrics,
+		events:  make(chan queuedEvent, size),
+	}
+	metrics.queueCapacity.Set(float64(size))
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue queues the event for processing. It returns false without blocking when the queue is
+// full or has been shut down.
+func (q *eventQueue) Enqueue(eventType string, process func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.events <- queuedEvent{eventType: eventType, enqueuedAt: time.Now(), process: process}:
+		q.metrics.queueDepth.Inc()
+		return true
+	default:
+		q.metrics.queueRejected.WithLabelValues(eventType).Inc()
+		return false
+	}
+}
+
+// Shutdown stops accepting events and waits until the queued events are processed or the context
+// is done.
+func (q *eventQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		_ = level.Warn(q.logger).Log("msg", "stopped waiting for queued events to be processed", "remaining", len(q.events))
+		return ctx.Err()
+	}
+}
+
+func (q *eventQueue) work() {
+	defer q.wg.Done()
+
+	for event := range q.events {
+		q.metrics.queueDepth.Dec()
+		q.metrics.queueWait.WithLabelValues(event.eventType).Observe(time.Since(event.enqueuedAt).Seconds())
+		event.process()
+	}
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EventQueue_DrainsQueuedEventsOnShutdown(t *testing.T) {
+	queue := server.NewEventQueue(log.NewNopLogger(), 100, 2, server.DefaultDeliveryMetrics())
+
+	var processed atomic.Int32
+	for i := 0; i < 50; i++ {
+		require.True(t, queue.Enqueue("workflow_job", func() {
+			time.Sleep(time.Millisecond)
+			processed.Add(1)
+		}))
+	}
+
+	require.NoError(t, queue.Shutdown(context.Background()))
+	assert.Equal(t, int32(50), processed.Load())
+	assert.False(t, queue.Enqueue("workflow_job", func() {}))
+}
+
+func Test_EventQueue_RejectsWhenFull(t *testing.T) {
+	queue := server.NewEventQueue(log.NewNopLogger(), 1, 0, server.DefaultDeliveryMetrics())
+
+	assert.True(t, queue.Enqueue("workflow_job", func() {}))
+	assert.False(t, queue.Enqueue("workflow_job", func() {}))
+}
+
+func Test_EventQueue_ShutdownStopsWaitingWhenContextIsDone(t *testing.T) {
+	queue := server.NewEventQueue(log.NewNopLogger(), 1, 1, server.DefaultDeliveryMetrics())
+	release := make(chan struct{})
+	defer close(release)
+	require.True(t, queue.Enqueue("workflow_job", func() { <-release }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, queue.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_RejectsWhenQueueIsFull(t *testing.T) {
+	// Given
+	subject, err := server.NewWorkflowMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), server.Opts{
+		GitHubToken:         "webhook-secret",
+		DeduplicationWindow: time.Hour,
+	})
+	require.NoError(t, err)
+	subject.SetQueue(server.NewEventQueue(subject.Logger, 1, 0, server.DefaultDeliveryMetrics()))
+
+	newRequest := func(deliveryID string) *http.Request {
+		payload := []byte(`{"action": "requested"}`)
+		mac := hmac.New(sha256.New, []byte("webhook-secret"))
+		_, _ = mac.Write(payload)
+
+		req := httptest.NewRequest("POST", "/anything", bytes.NewReader(payload))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-GitHub-Event", "workflow_run")
+		req.Header.Set("X-GitHub-Delivery", deliveryID)
+		return req
+	}
+
+	// When
+	accepted := httptest.NewRecorder()
+	subject.HandleGHWebHook(accepted, newRequest("first"))
+	rejected := httptest.NewRecorder()
+	subject.HandleGHWebHook(rejected, newRequest("second"))
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, accepted.Result().StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Result().StatusCode)
+	assert.Equal(t, server.RetryAfterSeconds, rejected.Result().Header.Get("Retry-After"))
+	assert.False(t, subject.Deliveries().Contains("second"), "a rejected delivery must be processed when redelivered")
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// expiringSet is a set of keys bounded in size, where every key is forgotten once its TTL has
+// passed. When the set is full the oldest keys are evicted first.
+type expiringSet struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	// order holds the entries sorted by expiry, oldest first.
+	order *list.List
+	now   func() time.Time
+	// shared holds the keys of the set shared with the other replicas under namespace, nil
+	// outside of the HA mode.
+	shared    sharedStore
+	namespace string
+}
+
+type expiringSetEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newExpiringSet(ttl time.Duration, maxEntries int) *expiringSet {
+	return &expiringSet{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// share shares the keys of the set with the other replicas through store, under namespace. A key
+// added by any replica is then reported as present by every replica. It must be called before the
+// set is used.
+func (s *expiringSet) share(store sharedStore, namespace string) {
+	s.shared = store
+	s.namespace = namespace
+}
+
+// Add adds the key to the set and reports whether it was not already present. When the set is
+// shared and the shared store fails, the replica decides alone.
+func (s *expiringSet) Add(key string) bool {
+	if !s.add(key) {
+		return false
+	}
+	if s.shared == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+	added, err := s.shared.Add(ctx, s.namespace+key, s.ttl)
+	if err != nil {
+		sharedStoreErrorsCounter.WithLabelValues("add").Inc()
+		return true
+	}
+	return added
+}
+
+func (s *expiringSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.evict(now)
+
+	if _, ok := s.entries[key]; ok {
+		return false
+	}
+
+	s.entries[key] = s.order.PushBack(&expiringSetEntry{key: key, expiresAt: now.Add(s.ttl)})
+	if s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Front())
+	}
+
+	return true
+}
+
+// Contains reports whether the key is in the set.
+func (s *expiringSet) Contains(key string) bool {
+	s.mu.Lock()
+	s.evict(s.now())
+	_, ok := s.entries[key]
+	s.mu.Unlock()
+	if ok || s.shared == nil {
+		return ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+	ok, err := s.shared.Contains(ctx, s.namespace+key)
+	if err != nil {
+		sharedStoreErrorsCounter.WithLabelValues("contains").Inc()
+		return false
+	}
+	return ok
+}
+
+// Remove removes the key from the set.
+func (s *expiringSet) Remove(key string) {
+	s.mu.Lock()
+	if element, ok := s.entries[key]; ok {
+		s.remove(element)
+	}
+	s.mu.Unlock()
+	if s.shared == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+	if err := s.shared.Remove(ctx, s.namespace+key); err != nil {
+		sharedStoreErrorsCounter.WithLabelValues("remove").Inc()
+	}
+}
+
+// Len returns the number of keys in the set.
+func (s *expiringSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(s.now())
+	return s.order.Len()
+}
+
+func (s *expiringSet) evict(now time.Time) {
+	for element := s.order.Front(); element != nil; element = s.order.Front() {
+		if element.Value.(*expiringSetEntry).expiresAt.After(now) {
+			return
+		}
+		s.remove(element)
+	}
+}
+
+func (s *expiringSet) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*expiringSetEntry).key)
+}
+
+// expiringKey is a key of an expiringSet with its expiry, as saved in the state of the exporter.
+type expiringKey struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// snapshot returns the keys of the set, oldest first.
+func (s *expiringSet) snapshot() []expiringKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(s.now())
+	keys := make([]expiringKey, 0, s.order.Len())
+	for element := s.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*expiringSetEntry)
+		keys = append(keys, expiringKey{Key: entry.key, ExpiresAt: entry.expiresAt})
+	}
+	return keys
+}
+
+// restore adds the keys that have not expired yet to the set, keeping their expiry.
+func (s *expiringSet) restore(keys []expiringKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.evict(now)
+	for _, key := range keys {
+		if _, ok := s.entries[key.Key]; ok || !key.ExpiresAt.After(now) {
+			continue
+		}
+		entry := &expiringSetEntry{key: key.Key, expiresAt: key.ExpiresAt}
+		// Keep the entries sorted by expiry.
+		element := s.order.Back()
+		for element != nil && element.Value.(*expiringSetEntry).expiresAt.After(key.ExpiresAt) {
+			element = element.Prev()
+		}
+		if element == nil {
+			s.entries[key.Key] = s.order.PushFront(entry)
+		} else {
+			s.entries[key.Key] = s.order.InsertAfter(entry, element)
+		}
+		if s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+			s.remove(s.order.Front())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExpiringSet_AddReportsDuplicates(t *testing.T) {
+	set := server.NewExpiringSet(time.Minute, 10)
+
+	assert.True(t, set.Add("a"))
+	assert.False(t, set.Add("a"))
+	assert.True(t, set.Add("b"))
+	assert.True(t, set.Contains("a"))
+	assert.Equal(t, 2, set.Len())
+
+	set.Remove("a")
+	assert.False(t, set.Contains("a"))
+	assert.True(t, set.Add("a"))
+}
+
+func Test_ExpiringSet_ForgetsExpiredKeys(t *testing.T) {
+	now := time.Unix(1650308740, 0)
+	set := server.NewExpiringSet(time.Minute, 10)
+	set.SetNow(func() time.Time { return now })
+
+	assert.True(t, set.Add("a"))
+	now = now.Add(30 * time.Second)
+	assert.True(t, set.Add("b"))
+	assert.False(t, set.Add("a"))
+
+	now = now.Add(31 * time.Second)
+	assert.False(t, set.Contains("a"))
+	assert.True(t, set.Contains("b"))
+	assert.True(t, set.Add("a"))
+}
+
+func Test_ExpiringSet_EvictsOldestWhenFull(t *testing.T) {
+	set := server.NewExpiringSet(time.Minute, 2)
+
+	assert.True(t, set.Add("a"))
+	assert.True(t, set.Add("b"))
+	assert.True(t, set.Add("c"))
+
+	assert.Equal(t, 2, set.Len())
+	assert.False(t, set.Contains("a"))
+	assert.True(t, set.Contains("b"))
+	assert.True(t, set.Contains("c"))
+}
+
+func Test_ExpiringSet_RestoresSnapshot(t *testing.T) {
+	now := time.Unix(1650308740, 0)
+	set := server.NewExpiringSet(time.Minute, 3)
+	set.SetNow(func() time.Time { return now })
+	set.Add("a")
+	now = now.Add(30 * time.Second)
+	set.Add("b")
+	set.Add("c")
+
+	restored := server.NewExpiringSet(time.Minute, 3)
+	restored.SetNow(func() time.Time { return now })
+	now = now.Add(15 * time.Second)
+	restored.Add("d")
+	restored.Restore(set.Snapshot())
+
+	assert.Equal(t, 3, restored.Len())
+	assert.False(t, restored.Contains("a"), "the oldest key is evicted when full")
+	assert.True(t, restored.Contains("d"))
+
+	now = now.Add(46 * time.Second)
+	assert.False(t, restored.Contains("b"), "the restored keys keep their expiry")
+	assert.True(t, restored.Contains("d"))
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The internals of the package used by the server_test tests.
+
+type (
+	AppTokenSource        = appTokenSource
+	DeliveryJournal       = deliveryJournal
+	JournalEntry          = journalEntry
+	JournalResponseWriter = journalResponseWriter
+	SharedStore           = sharedStore
+	UsageItem             = usageItem
+	UsageReport           = usageReport
+)
+
+const (
+	AppJWTClockSkew         = appJWTClockSkew
+	AppJWTLifetime          = appJWTLifetime
+	DeliveryResultAccepted  = deliveryResultAccepted
+	DeliveryResultDuplicate = deliveryResultDuplicate
+	DeliveryResultRejected  = deliveryResultRejected
+	JobStateInProgress      = jobStateInProgress
+	JobStateQueued          = jobStateQueued
+	JournalFileExt          = journalFileExt
+	JournalFilePrefix       = journalFilePrefix
+	RetryAfterSeconds       = retryAfterSeconds
+	RunnerStatusBusy        = runnerStatusBusy
+	RunnerStatusIdle        = runnerStatusIdle
+	RunnerStatusOffline     = runnerStatusOffline
+)
+
+var (
+	APIEndpoint                            = apiEndpoint
+	DeliveryResult                         = deliveryResult
+	ErrUnknownTarget                       = errUnknownTarget
+	IsLeader                               = isLeader
+	JobKey                                 = jobKey
+	NewDeliveryJournal                     = newDeliveryJournal
+	NewEventQueue                          = newEventQueue
+	NewExpiringSet                         = newExpiringSet
+	NewJobTracker                          = newJobTracker
+	NewLeaderElector                       = newLeaderElector
+	NewRateLimitTransport                  = newRateLimitTransport
+	NewSharedStore                         = newSharedStore
+	NewWorkflowMetricsExporterWithObserver = newWorkflowMetricsExporter
+	WithLeaderElector                      = withLeaderElector
+
+	CacheKeySizeGauge                 = cacheKeySizeGauge
+	CacheOrgCountGauge                = cacheOrgCountGauge
+	CacheOrgSizeGauge                 = cacheOrgSizeGauge
+	CacheRepoCountGauge               = cacheRepoCountGauge
+	CacheRepoSizeGauge                = cacheRepoSizeGauge
+	GitHubAPIRateLimitBackoffsCounter = githubAPIRateLimitBackoffsCounter
+	GitHubAPIRateLimitGauge           = githubAPIRateLimitGauge
+	GitHubAPIRateLimitRemainingGauge  = githubAPIRateLimitRemainingGauge
+	GitHubAPIRateLimitResetGauge      = githubAPIRateLimitResetGauge
+	GitHubAPIRequestsCounter          = githubAPIRequestsCounter
+	IncludedMinutesUsedActions        = includedMinutesUsedActions
+	JournalErrorsCounter              = journalErrorsCounter
+	NetAmountByOrgActions             = netAmountByOrgActions
+	PollerErrorsCounter               = pollerErrorsCounter
+	PollerLastSuccessGauge            = pollerLastSuccessGauge
+	RunnerBusyGauge                   = runnerBusyGauge
+	RunnerOnlineGauge                 = runnerOnlineGauge
+	RunnersByGroupGauge               = runnersByGroupGauge
+	RunnersByLabelsGauge              = runnersByLabelsGauge
+	TotalMinutesUsedActions           = totalMinutesUsedActions
+	TotalMinutesUsedByHostTypeActions = totalMinutesUsedByHostTypeActions
+	TotalMinutesUsedByOrgActions      = totalMinutesUsedByOrgActions
+	TotalPaidMinutesActions           = totalPaidMinutesActions
+	UsageGrossAmountActions           = usageGrossAmountActions
+	UsageMinutesActions               = usageMinutesActions
+	UsageNetAmountActions             = usageNetAmountActions
+)
+
+// DefaultDeliveryMetrics returns the delivery metrics registered with the default registerer.
+func DefaultDeliveryMetrics() *deliveryMetrics {
+	return defaultDeliveryMetrics
+}
+
+func NewAppTokenSource(appID int64, key *rsa.PrivateKey, now func() time.Time) *appTokenSource {
+	return &appTokenSource{appID: appID, key: key, now: now}
+}
+
+func NewFileStateStore(path string) *fileStateStore {
+	return &fileStateStore{path: path}
+}
+
+// NewStoppedDeliveryJournal returns a journal whose writer is not running, buffering up to size
+// deliveries.
+func NewStoppedDeliveryJournal(size int) *deliveryJournal {
+	return &deliveryJournal{logger: log.NewNopLogger(), now: time.Now, lines: make(chan []byte, size), done: make(chan struct{})}
+}
+
+func (j *deliveryJournal) Buffered() int {
+	return len(j.lines)
+}
+
+func (j *deliveryJournal) SetNow(now func() time.Time) {
+	j.now = now
+}
+
+func (d Delivery) Request(url, secret string) (*http.Request, error) {
+	return d.request(url, secret)
+}
+
+func (o Opts) AccountLabels(account GitHubAccount) (org, user, enterprise string) {
+	return o.accountLabels(account)
+}
+
+func (o Opts) OwnerLabel(owner string) string {
+	return o.ownerLabel(owner)
+}
+
+func (c *GitHubClients) ForOwner(ctx context.Context, owner string, findInstallation func(context.Context) (*github.Installation, *github.Response, error)) (*github.Client, error) {
+	return c.forOwner(ctx, owner, findInstallation)
+}
+
+func (t *rateLimitTransport) SetNow(now func() time.Time) {
+	t.now = now
+}
+
+func (t *rateLimitTransport) BlockedUntil() time.Time {
+	return t.blockedUntil
+}
+
+func (c *BillingMetricsExporter) CollectBilling(ctx context.Context, account GitHubAccount) error {
+	return c.collectBilling(ctx, account)
+}
+
+func (c *CacheMetricsExporter) CollectCacheUsage(ctx context.Context, org string) error {
+	return c.collectCacheUsage(ctx, org)
+}
+
+func (c *RunnersExporter) CollectRunners(ctx context.Context, target runnersTarget) error {
+	return c.collectRunners(ctx, target)
+}
+
+func (c *RunnersExporter) Targets() ([]runnersTarget, error) {
+	return c.targets()
+}
+
+func (c *WorkflowReconciler) Reconcile(ctx context.Context, target reconcileTarget) error {
+	return c.reconcile(ctx, target)
+}
+
+func (c *WorkflowReconciler) Targets() ([]reconcileTarget, error) {
+	return c.targets()
+}
+
+func (c *WorkflowMetricsExporter) DeliveryMetrics() *deliveryMetrics {
+	return c.deliveryMetrics()
+}
+
+func (c *WorkflowMetricsExporter) VerifyRequestSignature(header http.Header, body []byte) error {
+	return c.verifyRequestSignature(header, body)
+}
+
+func (c *WorkflowMetricsExporter) Deliveries() *expiringSet {
+	return c.deliveries
+}
+
+func (c *WorkflowMetricsExporter) Shared() sharedStore {
+	return c.shared
+}
+
+func (c *WorkflowMetricsExporter) SetQueue(queue *eventQueue) {
+	c.queue = queue
+}
+
+// TrackCompletions remembers the collected completions for ttl, as when reconciling.
+func (c *WorkflowMetricsExporter) TrackCompletions(ttl time.Duration) {
+	c.completions = newExpiringSet(ttl, 0)
+}
+
+func (o *PrometheusObserver) WorkflowJobStatusCounter() *prometheus.CounterVec {
+	return o.workflowJobStatusCounter
+}
+
+func (o *PrometheusObserver) WorkflowRunStatusCounter() *prometheus.CounterVec {
+	return o.workflowRunStatusCounter
+}
+
+func (m *deliveryMetrics) InFlightJobs(state string) *prometheus.GaugeVec {
+	return m.inFlightJobs(state)
+}
+
+func (w *journalResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *journalResponseWriter) Result() string {
+	return w.result
+}
+
+func (s *expiringSet) Restore(keys []expiringKey) {
+	s.restore(keys)
+}
+
+func (s *expiringSet) Snapshot() []expiringKey {
+	return s.snapshot()
+}
+
+func (s *expiringSet) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+func (t *jobTracker) Job(jobID int64) *trackedJob {
+	return t.jobs[jobID]
+}
+
+func (t *jobTracker) Started() *expiringSet {
+	return t.started
+}
+
+func (t *jobTracker) Remove(jobID int64, job *trackedJob) {
+	t.remove(jobID, job)
+}
+
+func (t *jobTracker) Restore(jobs []trackedJobState, started, completed []expiringKey) {
+	t.restore(jobs, started, completed)
+}
+
+func (t *jobTracker) Share(store sharedStore) {
+	t.share(store)
+}
+
+func (t *jobTracker) Snapshot() ([]trackedJobState, []expiringKey, []expiringKey) {
+	return t.snapshot()
+}
+
+func (t *jobTracker) SetNow(now func() time.Time) {
+	t.now = now
+}
+
+func (e *leaderElector) Campaign() {
+	e.campaign()
+}
+
+func (e *leaderElector) SetNow(now func() time.Time) {
+	e.now = now
+}
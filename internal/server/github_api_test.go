@@ -0,0 +1,41 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+)
+
+// testGitHubAPI serves a fake GitHub API whose routes are keyed by path, without the /api/v3
+// prefix, and responds 404 Not Found to the requests of other paths. It returns the options
+// authenticating to it with a token.
+func testGitHubAPI(t *testing.T, routes map[string]http.HandlerFunc) server.Opts {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := routes[strings.TrimPrefix(r.URL.Path, "/api/v3")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		route(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return server.Opts{GitHubAPIToken: "some-token", GitHubAPIURL: srv.URL}
+}
+
+// respondJSON returns a route responding with v encoded as JSON.
+func respondJSON(v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+}
+
+// respondStatus returns a route responding with the status code.
+func respondStatus(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}
+}
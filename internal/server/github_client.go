@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v66/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GitHub rejects app JWTs valid for more than 10 minutes, and clocks may drift a little.
+	appJWTLifetime  = 9 * time.Minute
+	appJWTClockSkew = time.Minute
+	// Tokens are refreshed a bit before they expire so that in flight requests don't fail.
+	tokenEarlyExpiry = time.Minute
+)
+
+// GitHubClients hands out the GitHub API clients used by the exporter. They are authenticated
+// either with a personal access token, or as a GitHub App installation whose installation
+// tokens are refreshed automatically.
+type GitHubClients struct {
+	// httpClient sends the requests of every client, through the configured proxy and trusting
+	// the configured CA certificates.
+	httpClient *http.Client
+	logger     log.Logger
+	// rateLimitThreshold is the remaining rate limit of a token below which its requests are held
+	// back until the rate limit is reset.
+	rateLimitThreshold int
+	// baseURL and uploadURL point the clients to GitHub Enterprise Server, nil for github.com.
+	baseURL   *url.URL
+	uploadURL *url.URL
+
+	// client is shared by every owner, nil when authenticating as a GitHub App installation
+	// that is discovered per owner.
+	client *github.Client
+	// appClient is authenticated as the GitHub App itself, nil without GitHub App credentials.
+	appClient *github.Client
+	// enterpriseClient is authenticated with a personal access token, as GitHub Apps can't access
+	// the enterprise endpoints. Nil without such a token.
+	enterpriseClient *github.Client
+
+	mu            sync.Mutex
+	installations map[string]*github.Client
+	// lookups holds the installation lookups in flight per owner, so that the concurrent callers
+	// wait for the same lookup instead of each looking the installation up.
+	lookups map[string]*installationLookup
+}
+
+// installationLookup is the lookup of the installation of an owner, whose client and error are
+// set once done is closed.
+type installationLookup struct {
+	done   chan struct{}
+	client *github.Client
+	err    error
+}
+
+// NewGitHubClients returns the GitHub API clients for the credentials configured in opts.
+func NewGitHubClients(logger log.Logger, opts Opts) (*GitHubClients, error) {
+	httpClient, err := newGitHubHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := &GitHubClients{
+		httpClient:         httpClient,
+		logger:             logger,
+		rateLimitThreshold: opts.GitHubAPIRateLimitThreshold,
+		installations:      map[string]*github.Client{},
+		lookups:            map[string]*installationLookup{},
+	}
+
+	if opts.GitHubAPIURL != "" {
+		uploadURL := opts.GitHubUploadURL
+		if uploadURL == "" {
+			uploadURL = strings.TrimSuffix(strings.TrimSuffix(opts.GitHubAPIURL, "/"), "/api/v3")
+		}
+		enterprise, err := github.NewClient(nil).WithEnterpriseURLs(opts.GitHubAPIURL, uploadURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing GitHub Enterprise URLs: %w", err)
+		}
+		clients.baseURL, clients.uploadURL = enterprise.BaseURL, enterprise.UploadURL
+	} else if opts.GitHubUploadURL != "" {
+		return nil, errors.New("GitHub upload URL requires the GitHub API URL")
+	}
+
+	switch {
+	case opts.GitHubAppID != 0:
+		if opts.GitHubAPIToken != "" {
+			return nil, errors.New("GitHub API token and GitHub App credentials are mutually exclusive")
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(opts.GitHubAppPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing GitHub App private key: %w", err)
+		}
+		clients.appClient = clients.newClient("app", &appTokenSource{appID: opts.GitHubAppID, key: key})
+		if opts.GitHubAppInstallationID != 0 {
+			clients.client = clients.installationClient(opts.GitHubAppInstallationID)
+		}
+	case opts.GitHubAppInstallationID != 0 || len(opts.GitHubAppPrivateKey) > 0:
+		return nil, errors.New("GitHub App installation and private key require the GitHub App ID")
+	case opts.GitHubAPIToken != "":
+		clients.client = clients.newClient("api-token", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: opts.GitHubAPIToken}))
+		clients.enterpriseClient = clients.client
+	}
+
+	if opts.GitHubEnterpriseToken != "" {
+		clients.enterpriseClient = clients.newClient("enterprise-token", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: opts.GitHubEnterpriseToken}))
+	}
+
+	return clients, nil
+}
+
+// Enabled reports whether credentials for the GitHub API are configured.
+func (c *GitHubClients) Enabled() bool {
+	return c != nil && (c.client != nil || c.appClient != nil || c.enterpriseClient != nil)
+}
+
+// ForEnterprise returns the client to access the GitHub API on behalf of an enterprise.
+func (c *GitHubClients) ForEnterprise(_ context.Context, enterprise string) (*github.Client, error) {
+	if c == nil || c.enterpriseClient == nil {
+		return nil, fmt.Errorf("no personal access token configured for enterprise %s", enterprise)
+	}
+	return c.enterpriseClient, nil
+}
+
+// ForOrg returns the client to access the GitHub API on behalf of an organization.
+func (c *GitHubClients) ForOrg(ctx context.Context, org string) (*github.Client, error) {
+	return c.forOwner(ctx, org, func(ctx context.Context) (*github.Installation, *github.Response, error) {
+		return c.appClient.Apps.FindOrganizationInstallation(ctx, org)
+	})
+}
+
+// ForUser returns the client to access the GitHub API on behalf of a user.
+func (c *GitHubClients) ForUser(ctx context.Context, user string) (*github.Client, error) {
+	return c.forOwner(ctx, user, func(ctx context.Context) (*github.Installation, *github.Response, error) {
+		return c.appClient.Apps.FindUserInstallation(ctx, user)
+	})
+}
+
+// ForRepository returns the client to access the GitHub API on behalf of the owner of a
+// repository, be it an organization or a user.
+func (c *GitHubClients) ForRepository(ctx context.Context, owner, repo string) (*github.Client, error) {
+	return c.forOwner(ctx, owner, func(ctx context.Context) (*github.Installation, *github.Response, error) {
+		return c.appClient.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	})
+}
+
+// forOwner returns the shared client or, when the GitHub App installation isn't configured,
+// the client of the installation found for owner.
+func (c *GitHubClients) forOwner(ctx context.Context, owner string, findInstallation func(context.Context) (*github.Installation, *github.Response, error)) (*github.Client, error) {
+	if c == nil || (c.client == nil && c.appClient == nil) {
+		return nil, errors.New("github credentials not configured")
+	}
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	key := strings.ToLower(owner)
+	c.mu.Lock()
+	if client, ok := c.installations[key]; ok {
+		c.mu.Unlock()
+		return client, nil
+	}
+	lookup, pending := c.lookups[key]
+	if !pending {
+		lookup = &installationLookup{done: make(chan struct{})}
+		c.lookups[key] = lookup
+	}
+	c.mu.Unlock()
+
+	if pending {
+		select {
+		case <-lookup.done:
+			return lookup.client, lookup.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// The lock isn't held while calling the GitHub API, so that the clients of the other owners
+	// are handed out meanwhile.
+	installation, _, err := findInstallation(ctx)
+	if err != nil {
+		lookup.err = fmt.Errorf("finding GitHub App installation for %s: %w", owner, err)
+	} else {
+		lookup.client = c.installationClient(installation.GetID())
+	}
+
+	c.mu.Lock()
+	delete(c.lookups, key)
+	if lookup.err == nil {
+		c.installations[key] = lookup.client
+	}
+	c.mu.Unlock()
+	close(lookup.done)
+
+	return lookup.client, lookup.err
+}
+
+// installationClient returns a client authenticated with the tokens of a GitHub App installation.
+func (c *GitHubClients) installationClient(installationID int64) *github.Client {
+	return c.newClient(fmt.Sprintf("installation-%d", installationID), &installationTokenSource{appClient: c.appClient, installationID: installationID})
+}
+
+// newClient returns a client authenticated with the tokens of src, reused until shortly before
+// they expire. The requests and the rate limit are reported under the given token name.
+func (c *GitHubClients) newClient(token string, src oauth2.TokenSource) *github.Client {
+	client := github.NewClient(&http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.ReuseTokenSourceWithExpiry(nil, src, tokenEarlyExpiry),
+		Base:   newRateLimitTransport(c.httpClient.Transport, c.logger, token, c.rateLimitThreshold),
+	}})
+	if c.baseURL != nil {
+		client.BaseURL, client.UploadURL = c.baseURL, c.uploadURL
+	}
+	return client
+}
+
+// newGitHubHTTPClient returns the HTTP client sending the GitHub API requests. Without a proxy
+// URL, the proxy is taken from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+func newGitHubHTTPClient(opts Opts) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(opts.GitHubCACertificates) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(opts.GitHubCACertificates) {
+			return nil, errors.New("no valid PEM encoded certificate in the GitHub CA certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if opts.GitHubProxyURL != "" {
+		proxyURL, err := url.Parse(opts.GitHubProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing GitHub proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// appTokenSource signs the JWTs authenticating as a GitHub App.
+type appTokenSource struct {
+	appID int64
+	key   *rsa.PrivateKey
+	now   func() time.Time
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	expiry := now.Add(appJWTLifetime)
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(s.appID, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-appJWTClockSkew)),
+		ExpiresAt: jwt.NewNumericDate(expiry),
+	}).SignedString(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("signing GitHub App JWT: %w", err)
+	}
+
+	return &oauth2.Token{AccessToken: signed, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// installationTokenSource creates installation access tokens of a GitHub App installation.
+type installationTokenSource struct {
+	appClient      *github.Client
+	installationID int64
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	token, _, err := s.appClient.Apps.CreateInstallationToken(ctx, s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating installation token for GitHub App installation %d: %w", s.installationID, err)
+	}
+
+	return &oauth2.Token{AccessToken: token.GetToken(), TokenType: "Bearer", Expiry: token.GetExpiresAt().Time}, nil
+}
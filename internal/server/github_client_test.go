@@ -0,0 +1,386 @@
+package server_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHubAppAPI serves the GitHub App endpoints and the org billing endpoint, counting the
+// requests made to each of them.
+type fakeGitHubAppAPI struct {
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeGitHubAppAPI(t *testing.T) (*fakeGitHubAppAPI, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	api := &fakeGitHubAppAPI{t: t, key: key, requests: map[string]int{}}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	return api, srv.URL
+}
+
+func (a *fakeGitHubAppAPI) privateKeyPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(a.key)})
+}
+
+func (a *fakeGitHubAppAPI) count(path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests[path]
+}
+
+func (a *fakeGitHubAppAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.ToLower(r.URL.Path), "/api/v3")
+	a.mu.Lock()
+	a.requests[path]++
+	a.mu.Unlock()
+
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch {
+	case strings.HasSuffix(path, "/installation") || strings.HasPrefix(path, "/app/"):
+		claims := jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(auth, &claims, func(*jwt.Token) (interface{}, error) {
+			return &a.key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"RS256"}))
+		if !assert.NoError(a.t, err) || !assert.Equal(a.t, "1234", claims.Issuer) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case auth != "installation-token-42":
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch path {
+	case "/orgs/some-org/installation", "/repos/someone/some-repo/installation":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": 42})
+	case "/app/installations/42/access_tokens":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "installation-token-42",
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	case "/orgs/some-org/settings/billing/actions":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"total_minutes_used": 10})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func Test_GitHubClients_NotConfigured(t *testing.T) {
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{})
+	require.NoError(t, err)
+
+	assert.False(t, clients.Enabled())
+	_, err = clients.ForOrg(context.Background(), "some-org")
+	assert.Error(t, err)
+}
+
+func Test_GitHubClients_Token(t *testing.T) {
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token"})
+	require.NoError(t, err)
+
+	assert.True(t, clients.Enabled())
+	orgClient, err := clients.ForOrg(context.Background(), "some-org")
+	require.NoError(t, err)
+	userClient, err := clients.ForUser(context.Background(), "someone")
+	require.NoError(t, err)
+	assert.Same(t, orgClient, userClient)
+}
+
+func Test_GitHubClients_InvalidAppCredentials(t *testing.T) {
+	for name, opts := range map[string]server.Opts{
+		"token and app":           {GitHubAPIToken: "some-token", GitHubAppID: 1234, GitHubAppPrivateKey: []byte("key")},
+		"invalid private key":     {GitHubAppID: 1234, GitHubAppPrivateKey: []byte("not a key")},
+		"installation without id": {GitHubAppInstallationID: 42},
+		"private key without id":  {GitHubAppPrivateKey: []byte("key")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_GitHubClients_AppInstallationDiscovery(t *testing.T) {
+	// Given
+	api, apiURL := newFakeGitHubAppAPI(t)
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{
+		GitHubAppID:         1234,
+		GitHubAppPrivateKey: api.privateKeyPEM(),
+		GitHubAPIURL:        apiURL,
+	})
+	require.NoError(t, err)
+
+	// When
+	for i := 0; i < 2; i++ {
+		client, err := clients.ForOrg(context.Background(), "Some-Org")
+		require.NoError(t, err)
+		billing, _, err := client.Billing.GetActionsBillingOrg(context.Background(), "some-org")
+		require.NoError(t, err)
+		assert.Equal(t, 10.0, billing.TotalMinutesUsed)
+	}
+
+	// Then the installation is looked up once and its token is reused
+	assert.Equal(t, 1, api.count("/orgs/some-org/installation"))
+	assert.Equal(t, 1, api.count("/app/installations/42/access_tokens"))
+	assert.Equal(t, 2, api.count("/orgs/some-org/settings/billing/actions"))
+}
+
+func Test_GitHubClients_ForRepository_DiscoversTheInstallationOfTheRepository(t *testing.T) {
+	// Given
+	api, apiURL := newFakeGitHubAppAPI(t)
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{
+		GitHubAppID:         1234,
+		GitHubAppPrivateKey: api.privateKeyPEM(),
+		GitHubAPIURL:        apiURL,
+	})
+	require.NoError(t, err)
+
+	// When
+	repoClient, err := clients.ForRepository(context.Background(), "someone", "some-repo")
+	require.NoError(t, err)
+	userClient, err := clients.ForUser(context.Background(), "Someone")
+	require.NoError(t, err)
+
+	// Then the installation of a user's repository is found without the org endpoint
+	assert.Same(t, repoClient, userClient)
+	assert.Equal(t, 1, api.count("/repos/someone/some-repo/installation"))
+	assert.Zero(t, api.count("/orgs/someone/installation"))
+	assert.Zero(t, api.count("/users/someone/installation"))
+}
+
+func Test_GitHubClients_LooksUpTheInstallationOfAnOwnerOnceWithoutBlockingTheOthers(t *testing.T) {
+	// Given
+	api, apiURL := newFakeGitHubAppAPI(t)
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{
+		GitHubAppID:         1234,
+		GitHubAppPrivateKey: api.privateKeyPEM(),
+		GitHubAPIURL:        apiURL,
+	})
+	require.NoError(t, err)
+	started, release := make(chan struct{}), make(chan struct{})
+	var lookups atomic.Int32
+	slowLookup := func(context.Context) (*github.Installation, *github.Response, error) {
+		if lookups.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return &github.Installation{ID: github.Int64(1)}, nil, nil
+	}
+
+	// When
+	results := make(chan *github.Client, 2)
+	for range 2 {
+		go func() {
+			client, err := clients.ForOwner(context.Background(), "slow-org", slowLookup)
+			assert.NoError(t, err)
+			results <- client
+		}()
+	}
+	<-started
+	other, err := clients.ForOwner(context.Background(), "other-org", func(context.Context) (*github.Installation, *github.Response, error) {
+		return &github.Installation{ID: github.Int64(2)}, nil, nil
+	})
+
+	// Then the other owners are served while an installation is looked up
+	require.NoError(t, err)
+	assert.NotNil(t, other)
+	close(release)
+	first, second := <-results, <-results
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), lookups.Load(), "the concurrent callers share the lookup")
+}
+
+func Test_GitHubClients_AppInstallation(t *testing.T) {
+	// Given
+	api, apiURL := newFakeGitHubAppAPI(t)
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{
+		GitHubAppID:             1234,
+		GitHubAppPrivateKey:     api.privateKeyPEM(),
+		GitHubAppInstallationID: 42,
+		GitHubAPIURL:            apiURL,
+	})
+	require.NoError(t, err)
+
+	// When
+	client, err := clients.ForOrg(context.Background(), "some-org")
+	require.NoError(t, err)
+	_, _, err = client.Billing.GetActionsBillingOrg(context.Background(), "some-org")
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 0, api.count("/orgs/some-org/installation"))
+	assert.Equal(t, 1, api.count("/app/installations/42/access_tokens"))
+}
+
+func Test_GitHubClients_EnterpriseURLs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		apiURL, uploadURL            string
+		expectedBase, expectedUpload string
+	}{
+		"host only": {
+			apiURL:         "https://github.example.com",
+			expectedBase:   "https://github.example.com/api/v3/",
+			expectedUpload: "https://github.example.com/api/uploads/",
+		},
+		"api path": {
+			apiURL:         "https://github.example.com/api/v3/",
+			expectedBase:   "https://github.example.com/api/v3/",
+			expectedUpload: "https://github.example.com/api/uploads/",
+		},
+		"upload url": {
+			apiURL:         "https://github.example.com/api/v3",
+			uploadURL:      "https://uploads.github.example.com",
+			expectedBase:   "https://github.example.com/api/v3/",
+			expectedUpload: "https://uploads.github.example.com/api/uploads/",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token", GitHubAPIURL: tc.apiURL, GitHubUploadURL: tc.uploadURL})
+			require.NoError(t, err)
+
+			client, err := clients.ForOrg(context.Background(), "some-org")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBase, client.BaseURL.String())
+			assert.Equal(t, tc.expectedUpload, client.UploadURL.String())
+		})
+	}
+}
+
+func Test_GitHubClients_CACertificates(t *testing.T) {
+	// Given
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"total_minutes_used": 10})
+	}))
+	t.Cleanup(srv.Close)
+	caCertificates := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	for name, tc := range map[string]struct {
+		caCertificates []byte
+		expectError    bool
+	}{
+		"untrusted": {expectError: true},
+		"trusted":   {caCertificates: caCertificates},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token", GitHubAPIURL: srv.URL, GitHubCACertificates: tc.caCertificates})
+			require.NoError(t, err)
+			client, err := clients.ForOrg(context.Background(), "some-org")
+			require.NoError(t, err)
+
+			// When
+			_, _, err = client.Billing.GetActionsBillingOrg(context.Background(), "some-org")
+
+			// Then
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	_, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token", GitHubCACertificates: []byte("not a certificate")})
+	assert.Error(t, err)
+}
+
+func Test_GitHubClients_Proxy(t *testing.T) {
+	// Given
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"total_minutes_used": 10})
+	}))
+	t.Cleanup(proxy.Close)
+
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{
+		GitHubAPIToken: "some-token",
+		GitHubAPIURL:   "http://github.example.com/api/v3/",
+		GitHubProxyURL: proxy.URL,
+	})
+	require.NoError(t, err)
+	client, err := clients.ForOrg(context.Background(), "some-org")
+	require.NoError(t, err)
+
+	// When
+	_, _, err = client.Billing.GetActionsBillingOrg(context.Background(), "some-org")
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, "github.example.com", proxiedHost)
+}
+
+func Test_appTokenSource_Token(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	now := time.Unix(1650308740, 0)
+	src := server.NewAppTokenSource(1234, key, func() time.Time { return now })
+
+	token, err := src.Token()
+	require.NoError(t, err)
+
+	claims := jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(token.AccessToken, &claims, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithTimeFunc(func() time.Time { return now }))
+	require.NoError(t, err)
+	assert.Equal(t, "1234", claims.Issuer)
+	assert.Equal(t, now.Add(-server.AppJWTClockSkew), claims.IssuedAt.Time)
+	assert.Equal(t, now.Add(server.AppJWTLifetime), claims.ExpiresAt.Time)
+	assert.Equal(t, now.Add(server.AppJWTLifetime), token.Expiry)
+}
+
+func Test_Opts_ownerLabel(t *testing.T) {
+	assert.Equal(t, "some-org", server.Opts{}.OwnerLabel("some-org"))
+	assert.Equal(t, "github.example.com/some-org", server.Opts{GitHubAPIURL: "https://github.example.com/api/v3/"}.OwnerLabel("some-org"))
+	assert.Equal(t, "", server.Opts{GitHubAPIURL: "https://github.example.com/api/v3/"}.OwnerLabel(""))
+}
+
+func Test_GitHubClients_ForEnterprise(t *testing.T) {
+	api, _ := newFakeGitHubAppAPI(t)
+	appClients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAppID: 1234, GitHubAppPrivateKey: api.privateKeyPEM(), GitHubAppInstallationID: 42})
+	require.NoError(t, err)
+	_, err = appClients.ForEnterprise(context.Background(), "some-enterprise")
+	assert.Error(t, err, "GitHub Apps can't access the enterprise endpoints")
+
+	tokenClients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubAPIToken: "some-token"})
+	require.NoError(t, err)
+	client, err := tokenClients.ForEnterprise(context.Background(), "some-enterprise")
+	require.NoError(t, err)
+	orgClient, err := tokenClients.ForOrg(context.Background(), "some-org")
+	require.NoError(t, err)
+	assert.Same(t, orgClient, client)
+
+	enterpriseClients, err := server.NewGitHubClients(log.NewNopLogger(), server.Opts{GitHubEnterpriseToken: "enterprise-token"})
+	require.NoError(t, err)
+	assert.True(t, enterpriseClients.Enabled())
+	_, err = enterpriseClients.ForEnterprise(context.Background(), "some-enterprise")
+	require.NoError(t, err)
+	_, err = enterpriseClients.ForOrg(context.Background(), "some-org")
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// rateLimitResourceCore is the rate limit resource of the REST API endpoints polled by the exporter.
+const rateLimitResourceCore = "core"
+
+// Path segments followed by one variable segment, respectively two for repos.
+var (
+	apiOwnerSegments = map[string]bool{"orgs": true, "organizations": true, "users": true, "enterprises": true}
+	apiRepoSegment   = "repos"
+)
+
+// rateLimitTransport instruments the requests sent with a token to the GitHub API and, once the
+// remaining rate limit of the token falls to the threshold, holds the requests back until the
+// rate limit is reset.
+type rateLimitTransport struct {
+	base      http.RoundTripper
+	logger    log.Logger
+	token     string
+	threshold int
+	now       func() time.Time
+
+	mu sync.Mutex
+	// blockedUntil is the time until which requests are held back.
+	blockedUntil time.Time
+}
+
+func newRateLimitTransport(base http.RoundTripper, logger log.Logger, token string, threshold int) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:      base,
+		logger:    logger,
+		token:     token,
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	endpoint := apiEndpoint(req.Method, req.URL.Path)
+	start := t.now()
+	res, err := t.base.RoundTrip(req)
+	githubAPIRequestDurationHistogram.WithLabelValues(endpoint).Observe(t.now().Sub(start).Seconds())
+	if err != nil {
+		githubAPIRequestsCounter.WithLabelValues(endpoint, "error").Inc()
+		return nil, err
+	}
+	githubAPIRequestsCounter.WithLabelValues(endpoint, strconv.Itoa(res.StatusCode)).Inc()
+
+	t.update(res)
+	return res, nil
+}
+
+// wait holds a request back while the rate limit of the token is exhausted.
+func (t *rateLimitTransport) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := t.blockedUntil.Sub(t.now())
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	githubAPIRateLimitBackoffsCounter.WithLabelValues(t.token).Inc()
+	_ = level.Warn(t.logger).Log("msg", "GitHub API rate limit almost exhausted, holding requests back", "token", t.token, "delay", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the GitHub API rate limit reset: %w", ctx.Err())
+	}
+}
+
+// update exports the rate limit of the token from the response headers, and holds the next
+// requests back when the remaining rate limit reached the threshold or GitHub asked to retry later.
+func (t *rateLimitTransport) update(res *http.Response) {
+	resource := res.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = rateLimitResourceCore
+	}
+
+	remaining, remainingErr := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	limit, limitErr := strconv.Atoi(res.Header.Get("X-RateLimit-Limit"))
+	reset, resetErr := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if remainingErr == nil && limitErr == nil && resetErr == nil {
+		githubAPIRateLimitRemainingGauge.WithLabelValues(t.token, resource).Set(float64(remaining))
+		githubAPIRateLimitGauge.WithLabelValues(t.token, resource).Set(float64(limit))
+		githubAPIRateLimitResetGauge.WithLabelValues(t.token, resource).Set(float64(reset))
+	}
+
+	var blockedUntil time.Time
+	switch {
+	case res.Header.Get("Retry-After") != "" && (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests):
+		// Secondary rate limit.
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			blockedUntil = t.now().Add(time.Duration(seconds) * time.Second)
+		}
+	case resource == rateLimitResourceCore && remainingErr == nil && resetErr == nil && remaining <= t.threshold:
+		blockedUntil = time.Unix(reset, 0)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if blockedUntil.After(t.blockedUntil) {
+		t.blockedUntil = blockedUntil
+	}
+}
+
+// apiEndpoint returns the endpoint of a GitHub API request, its method and path with the
+// owners, repositories and IDs replaced by placeholders to keep the cardinality low, e.g.
+// "GET /orgs/{owner}/actions/runner-groups/{id}/runners".
+func apiEndpoint(method, path string) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/v3"), "/"), "/")
+	for i := 0; i < len(segments); i++ {
+		switch {
+		case apiOwnerSegments[segments[i]] && i+1 < len(segments):
+			segments[i+1] = "{owner}"
+			i++
+		case segments[i] == apiRepoSegment && i+2 < len(segments):
+			segments[i+1], segments[i+2] = "{owner}", "{repo}"
+			i += 2
+		default:
+			if _, err := strconv.ParseInt(segments[i], 10, 64); err == nil {
+				segments[i] = "{id}"
+			}
+		}
+	}
+	return method + " /" + strings.Join(segments, "/")
+}
@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_APIEndpoint(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/orgs/some-org/settings/billing/actions", "GET /orgs/{owner}/settings/billing/actions"},
+		{"GET", "/api/v3/orgs/some-org/actions/runner-groups/12/runners", "GET /orgs/{owner}/actions/runner-groups/{id}/runners"},
+		{"GET", "/repos/some-org/some-repo/actions/runners", "GET /repos/{owner}/{repo}/actions/runners"},
+		{"GET", "/organizations/some-org/settings/billing/usage", "GET /organizations/{owner}/settings/billing/usage"},
+		{"POST", "/app/installations/42/access_tokens", "POST /app/installations/{id}/access_tokens"},
+		{"GET", "/rate_limit", "GET /rate_limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, server.APIEndpoint(tt.method, tt.path))
+		})
+	}
+}
+
+func Test_RateLimitTransport_ExportsRateLimit(t *testing.T) {
+	// Given
+	reset := time.Now().Add(time.Hour).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.Header().Set("X-RateLimit-Resource", "core")
+	}))
+	t.Cleanup(srv.Close)
+	client := &http.Client{Transport: server.NewRateLimitTransport(http.DefaultTransport, log.NewNopLogger(), "export-token", 100)}
+
+	// When
+	res, err := client.Get(srv.URL + "/orgs/some-org/actions/runners")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	// Then
+	assert.Equal(t, 4999.0, testutil.ToFloat64(server.GitHubAPIRateLimitRemainingGauge.WithLabelValues("export-token", "core")))
+	assert.Equal(t, 5000.0, testutil.ToFloat64(server.GitHubAPIRateLimitGauge.WithLabelValues("export-token", "core")))
+	assert.Equal(t, float64(reset), testutil.ToFloat64(server.GitHubAPIRateLimitResetGauge.WithLabelValues("export-token", "core")))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(server.GitHubAPIRequestsCounter.WithLabelValues("GET /orgs/{owner}/actions/runners", "200")), 1.0)
+}
+
+func Test_RateLimitTransport_BacksOffBelowThreshold(t *testing.T) {
+	// Given
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	}))
+	t.Cleanup(srv.Close)
+	client := &http.Client{Transport: server.NewRateLimitTransport(http.DefaultTransport, log.NewNopLogger(), "backoff-token", 100)}
+	res, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	// When
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+
+	// Then
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, requests, "the request is held back")
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.GitHubAPIRateLimitBackoffsCounter.WithLabelValues("backoff-token")))
+}
+
+func Test_RateLimitTransport_RetryAfter(t *testing.T) {
+	// Given
+	now := time.Now()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+	transport := server.NewRateLimitTransport(http.DefaultTransport, log.NewNopLogger(), "retry-token", 100)
+	transport.SetNow(func() time.Time { return now })
+	client := &http.Client{Transport: transport}
+
+	// When
+	res, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	// Then
+	assert.Equal(t, now.Add(30*time.Second), transport.BlockedUntil())
+}
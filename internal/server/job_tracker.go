@@ -0,0 +1,278 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	jobStateQueued     = "queued"
+	jobStateInProgress = "in_progress"
+
+	// The recently started and completed jobs are remembered for the TTL, up to this many each.
+	jobTrackerMaxEntries = 200000
+)
+
+// jobTracker follows the lifecycle of workflow jobs, from queued to in_progress to completed, and
+// keeps the workflow_jobs_queued and workflow_jobs_in_progress gauges up to date. Jobs that have
+// not been updated within the TTL are considered lost and stop being counted.
+type jobTracker struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	metrics *deliveryMetrics
+	jobs    map[int64]*trackedJob
+	// started remembers recently started jobs, so that a queued event delivered after the
+	// in_progress one to another replica does not count the job again.
+	started *expiringSet
+	// completed remembers recently completed jobs, so that a queued or in_progress event
+	// delivered after the completed one does not count the job again.
+	completed *expiringSet
+	now       func() time.Time
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type trackedJob struct {
+	state    string
+	labels   inFlightJobLabels
+	lastSeen time.Time
+}
+
+type inFlightJobLabels struct {
+	org, repo, runnerGroup, runnerLabels string
+}
+
+func newJobTracker(ttl time.Duration, metrics *deliveryMetrics) *jobTracker {
+	return &jobTracker{
+		ttl:       ttl,
+		metrics:   metrics,
+		jobs:      map[int64]*trackedJob{},
+		completed: newExpiringSet(ttl, jobTrackerMaxEntries),
+		started:   newExpiringSet(ttl, jobTrackerMaxEntries),
+		now:       time.Now,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start expires the stale jobs periodically until Stop is called.
+func (t *jobTracker) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Expire()
+				t.Sync()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops expiring the stale jobs.
+func (t *jobTracker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// Observe records the state transition of a workflow job.
+func (t *jobTracker) Observe(event *github.WorkflowJobEvent) {
+	workflowJob := event.GetWorkflowJob()
+	jobID := workflowJob.GetID()
+	labels := inFlightJobLabels{
+		org:          event.GetRepo().GetOwner().GetLogin(),
+		repo:         event.GetRepo().GetName(),
+		runnerGroup:  workflowJob.GetRunnerGroupName(),
+		runnerLabels: sortedRunnerLabels(workflowJob),
+	}
+
+	// The started and completed jobs may be shared with the other replicas, they are queried
+	// without holding the lock.
+	key := jobKey(jobID)
+	var started, completed bool
+	switch event.GetAction() {
+	case "queued", "waiting":
+		completed = t.completed.Contains(key)
+		started = !completed && t.started.Contains(key)
+	case "in_progress":
+		t.started.Add(key)
+		completed = t.completed.Contains(key)
+	case "completed":
+		t.completed.Add(key)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job, tracked := t.jobs[jobID]
+	switch event.GetAction() {
+	case "queued", "waiting":
+		if tracked {
+			// Keep the state of a job already in progress, the queued event arrived late.
+			job.lastSeen = t.now()
+			return
+		}
+		if completed || started {
+			return
+		}
+		t.add(jobID, jobStateQueued, labels)
+	case "in_progress":
+		if tracked && job.state == jobStateInProgress {
+			job.lastSeen = t.now()
+			return
+		}
+		if tracked {
+			t.remove(jobID, job)
+		} else if completed {
+			return
+		}
+		t.add(jobID, jobStateInProgress, labels)
+	case "completed":
+		if tracked {
+			t.remove(jobID, job)
+		}
+	}
+}
+
+// Expire stops counting the jobs that have not been updated within the TTL.
+func (t *jobTracker) Expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deadline := t.now().Add(-t.ttl)
+	for jobID, job := range t.jobs {
+		if job.lastSeen.Before(deadline) {
+			t.remove(jobID, job)
+			t.metrics.jobsExpired.WithLabelValues(job.state).Inc()
+		}
+	}
+}
+
+// share shares the started and completed jobs with the other replicas through store, so that a
+// job is counted by a single replica. It must be called before the tracker is used.
+func (t *jobTracker) share(store sharedStore) {
+	t.started.share(store, "started_jobs/")
+	t.completed.share(store, "completed_jobs/")
+}
+
+// Sync stops counting the jobs that progressed on other replicas: the queued jobs that started
+// and the jobs that completed.
+func (t *jobTracker) Sync() {
+	if t.completed.shared == nil {
+		return
+	}
+
+	t.mu.Lock()
+	jobs := make(map[int64]string, len(t.jobs))
+	for jobID, job := range t.jobs {
+		jobs[jobID] = job.state
+	}
+	t.mu.Unlock()
+
+	// The shared store is queried without holding the lock.
+	var progressed []int64
+	for jobID, state := range jobs {
+		if t.completed.Contains(jobKey(jobID)) || (state == jobStateQueued && t.started.Contains(jobKey(jobID))) {
+			progressed = append(progressed, jobID)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, jobID := range progressed {
+		if job, tracked := t.jobs[jobID]; tracked && job.state == jobs[jobID] {
+			t.remove(jobID, job)
+		}
+	}
+}
+
+func (t *jobTracker) add(jobID int64, state string, labels inFlightJobLabels) {
+	t.jobs[jobID] = &trackedJob{state: state, labels: labels, lastSeen: t.now()}
+	t.metrics.inFlightJobs(state).WithLabelValues(labels.org, labels.repo, labels.runnerGroup, labels.runnerLabels).Inc()
+}
+
+func (t *jobTracker) remove(jobID int64, job *trackedJob) {
+	delete(t.jobs, jobID)
+	t.metrics.inFlightJobs(job.state).WithLabelValues(job.labels.org, job.labels.repo, job.labels.runnerGroup, job.labels.runnerLabels).Dec()
+}
+
+func jobKey(jobID int64) string {
+	return strconv.FormatInt(jobID, 10)
+}
+
+// sortedRunnerLabels returns the sorted labels a workflow job requested with `runs-on:`, comma separated.
+func sortedRunnerLabels(workflowJob *github.WorkflowJob) string {
+	labels := append([]string(nil), workflowJob.Labels...)
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// trackedJobState is a tracked job as saved in the state of the exporter.
+type trackedJobState struct {
+	ID           int64     `json:"id"`
+	State        string    `json:"state"`
+	Org          string    `json:"org"`
+	Repo         string    `json:"repo"`
+	RunnerGroup  string    `json:"runner_group,omitempty"`
+	RunnerLabels string    `json:"runner_labels,omitempty"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// snapshot returns the tracked jobs and the recently started and completed ones.
+func (t *jobTracker) snapshot() ([]trackedJobState, []expiringKey, []expiringKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jobs := make([]trackedJobState, 0, len(t.jobs))
+	for jobID, job := range t.jobs {
+		jobs = append(jobs, trackedJobState{
+			ID:           jobID,
+			State:        job.state,
+			Org:          job.labels.org,
+			Repo:         job.labels.repo,
+			RunnerGroup:  job.labels.runnerGroup,
+			RunnerLabels: job.labels.runnerLabels,
+			LastSeen:     job.lastSeen,
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, t.started.snapshot(), t.completed.snapshot()
+}
+
+// restore tracks the jobs that have not been updated within the TTL again, unless they are
+// already tracked, completed or, when queued, started.
+func (t *jobTracker) restore(jobs []trackedJobState, started, completed []expiringKey) {
+	t.started.restore(started)
+	t.completed.restore(completed)
+
+	// The started and completed jobs may be shared with the other replicas, they are queried
+	// without holding the lock.
+	deadline := t.now().Add(-t.ttl)
+	restored := make([]trackedJobState, 0, len(jobs))
+	for _, job := range jobs {
+		if job.LastSeen.Before(deadline) || (job.State != jobStateQueued && job.State != jobStateInProgress) {
+			continue
+		}
+		if t.completed.Contains(jobKey(job.ID)) || (job.State == jobStateQueued && t.started.Contains(jobKey(job.ID))) {
+			continue
+		}
+		restored = append(restored, job)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range restored {
+		if _, tracked := t.jobs[job.ID]; tracked {
+			continue
+		}
+		t.add(job.ID, job.State, inFlightJobLabels{org: job.Org, repo: job.Repo, runnerGroup: job.RunnerGroup, runnerLabels: job.RunnerLabels})
+		t.jobs[job.ID].lastSeen = job.LastSeen
+	}
+}
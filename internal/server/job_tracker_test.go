@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func testJobEvent(org, action string, jobID int64, runnerGroup string) *github.WorkflowJobEvent {
+	return &github.WorkflowJobEvent{
+		Action: github.String(action),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String(org)},
+		},
+		WorkflowJob: &github.WorkflowJob{
+			ID:              github.Int64(jobID),
+			Labels:          []string{"self-hosted", "gpu", "linux"},
+			RunnerGroupName: github.String(runnerGroup),
+		},
+	}
+}
+
+func inFlightJobs(state, org, runnerGroup string) float64 {
+	return testutil.ToFloat64(server.DefaultDeliveryMetrics().InFlightJobs(state).WithLabelValues(org, "some-repo", runnerGroup, "gpu,linux,self-hosted"))
+}
+
+func Test_JobTracker_FollowsJobLifecycle(t *testing.T) {
+	org := "lifecycle-org"
+	tracker := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+
+	tracker.Observe(testJobEvent(org, "queued", 1, ""))
+	tracker.Observe(testJobEvent(org, "queued", 2, ""))
+	assert.Equal(t, 2.0, inFlightJobs(server.JobStateQueued, org, ""))
+
+	tracker.Observe(testJobEvent(org, "in_progress", 1, "gpu-pool"))
+	tracker.Observe(testJobEvent(org, "in_progress", 1, "gpu-pool"))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateInProgress, org, "gpu-pool"))
+
+	tracker.Observe(testJobEvent(org, "completed", 1, "gpu-pool"))
+	tracker.Observe(testJobEvent(org, "completed", 2, ""))
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateQueued, org, ""))
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateInProgress, org, "gpu-pool"))
+}
+
+func Test_JobTracker_HandlesOutOfOrderEvents(t *testing.T) {
+	org := "out-of-order-org"
+	tracker := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+
+	tracker.Observe(testJobEvent(org, "in_progress", 1, "gpu-pool"))
+	tracker.Observe(testJobEvent(org, "queued", 1, ""))
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateQueued, org, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateInProgress, org, "gpu-pool"))
+
+	tracker.Observe(testJobEvent(org, "completed", 2, "gpu-pool"))
+	tracker.Observe(testJobEvent(org, "queued", 2, ""))
+	tracker.Observe(testJobEvent(org, "in_progress", 2, "gpu-pool"))
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateQueued, org, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateInProgress, org, "gpu-pool"))
+}
+
+func Test_JobTracker_ExpiresStaleJobs(t *testing.T) {
+	org := "stale-org"
+	now := time.Unix(1650308740, 0)
+	tracker := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+	tracker.SetNow(func() time.Time { return now })
+
+	tracker.Observe(testJobEvent(org, "queued", 1, ""))
+	now = now.Add(30 * time.Minute)
+	tracker.Observe(testJobEvent(org, "queued", 2, ""))
+
+	now = now.Add(31 * time.Minute)
+	tracker.Expire()
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""))
+
+	now = now.Add(30 * time.Minute)
+	tracker.Expire()
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateQueued, org, ""))
+}
+
+func Test_JobTracker_RestoresSnapshot(t *testing.T) {
+	org := "restored-org"
+	now := time.Unix(1650308740, 0)
+	tracker := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+	tracker.SetNow(func() time.Time { return now })
+	tracker.Observe(testJobEvent(org, "queued", 1, ""))
+	tracker.Observe(testJobEvent(org, "in_progress", 2, "gpu-pool"))
+	tracker.Observe(testJobEvent(org, "completed", 3, ""))
+	jobs, started, completed := tracker.Snapshot()
+	tracker.Stop()
+	tracker.Remove(1, tracker.Job(1))
+	tracker.Remove(2, tracker.Job(2))
+
+	restored := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+	restored.SetNow(func() time.Time { return now.Add(time.Minute) })
+	restored.Restore(jobs, started, completed)
+
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateInProgress, org, "gpu-pool"))
+	assert.True(t, restored.Started().Contains(server.JobKey(2)), "the started jobs are restored")
+	restored.Observe(testJobEvent(org, "queued", 3, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""), "a completed job is not counted again")
+
+	restored.SetNow(func() time.Time { return now.Add(61 * time.Minute) })
+	restored.Expire()
+	assert.Equal(t, 0.0, inFlightJobs(server.JobStateQueued, org, ""), "the restored jobs keep their last update")
+}
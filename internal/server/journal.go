@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Responses to the deliveries recorded in the journal.
+const (
+	deliveryResultAccepted       = "accepted"
+	deliveryResultDuplicate      = "duplicate"
+	deliveryResultRejected       = "rejected"
+	deliveryResultQueueFull      = "queue_full"
+	deliveryResultNotImplemented = "not_implemented"
+	deliveryResultInvalid        = "invalid"
+	deliveryResultError          = "error"
+)
+
+const (
+	journalFilePrefix = "deliveries-"
+	journalFileExt    = ".jsonl"
+	// journalTimeLayout names the journal files after the time they were created, so that they
+	// sort in the order they were written.
+	journalTimeLayout = "20060102T150405.000000000Z"
+	// journalBufferSize is the number of deliveries waiting to be written to the journal at most.
+	journalBufferSize = 1024
+)
+
+var errJournalFull = errors.New("too many deliveries waiting to be written")
+
+// journalEntry is a delivery recorded in the journal. It embeds the delivery, so that the
+// journal files can be replayed. Status and Response tell how the delivery was answered, not
+// whether its event was processed successfully, which happens afterwards on the event queue.
+type journalEntry struct {
+	Time time.Time `json:"timestamp"`
+	Delivery
+	Status   int    `json:"status"`
+	Response string `json:"response"`
+}
+
+// deliveryJournal records the webhook deliveries as JSONL files in a directory. The deliveries
+// are written in the background, and dropped when too many are waiting to be written. A file is
+// rotated once it reaches the maximum size, and the rotated files beyond the maximum number of
+// files or older than the retention are removed.
+type deliveryJournal struct {
+	logger      log.Logger
+	dir         string
+	maxFileSize int64
+	maxFiles    int
+	retention   time.Duration
+	// capture records the rejected deliveries too, along with their headers.
+	capture bool
+	now     func() time.Time
+
+	lines chan []byte
+	done  chan struct{}
+	// mu guards closed, so that no line is sent once the lines channel is closed.
+	mu     sync.RWMutex
+	closed bool
+
+	// file and size are only accessed by the writer.
+	file *os.File
+	size int64
+}
+
+func newDeliveryJournal(logger log.Logger, opts Opts) *deliveryJournal {
+	j := &deliveryJournal{
+		logger:      logger,
+		dir:         opts.JournalDir,
+		maxFileSize: opts.JournalMaxFileSize,
+		maxFiles:    opts.JournalMaxFiles,
+		retention:   opts.JournalRetention,
+		capture:     opts.JournalCapture,
+		now:         time.Now,
+		lines:       make(chan []byte, journalBufferSize),
+		done:        make(chan struct{}),
+	}
+	go j.writeLines()
+	return j
+}
+
+// Record queues a delivery for the journal with the response it was answered with, without
+// blocking. Outside of the capture mode, only the accepted deliveries are recorded and without
+// their headers.
+func (j *deliveryJournal) Record(header http.Header, body []byte, status int, response string) {
+	accepted := status >= 200 && status < 300
+	if !accepted && !j.capture {
+		return
+	}
+
+	entry := journalEntry{
+		Time: j.now().UTC(),
+		Delivery: Delivery{
+			ID:    header.Get("X-GitHub-Delivery"),
+			Event: header.Get("X-GitHub-Event"),
+		},
+		Status:   status,
+		Response: response,
+	}
+	if entry.Response == "" {
+		entry.Response = deliveryResult(status)
+	}
+	if j.capture {
+		entry.Headers = map[string]string{}
+		for key := range header {
+			entry.Headers[key] = header.Get(key)
+		}
+	}
+	// The body is kept as a string, byte for byte, as its signature was computed over it.
+	rawBody, err := json.Marshal(string(body))
+	if err != nil {
+		j.fail(err)
+		return
+	}
+	entry.Body = rawBody
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		j.fail(err)
+		return
+	}
+	j.enqueue(append(line, '\n'))
+}
+
+func (j *deliveryJournal) enqueue(line []byte) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if j.closed {
+		return
+	}
+	select {
+	case j.lines <- line:
+	default:
+		j.fail(errJournalFull)
+	}
+}
+
+// Close writes the queued deliveries and closes the current journal file.
+func (j *deliveryJournal) Close() error {
+	j.mu.Lock()
+	if !j.closed {
+		j.closed = true
+		close(j.lines)
+	}
+	j.mu.Unlock()
+	<-j.done
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// writeLines writes the queued lines until the journal is closed.
+func (j *deliveryJournal) writeLines() {
+	defer close(j.done)
+
+	for line := range j.lines {
+		if err := j.write(line); err != nil {
+			j.fail(err)
+		}
+	}
+}
+
+func (j *deliveryJournal) write(line []byte) error {
+	if j.file != nil && j.maxFileSize > 0 && j.size+int64(len(line)) > j.maxFileSize {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.file = nil
+	}
+	if j.file == nil {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// rotate opens a new journal file and removes the files beyond the retention policy.
+func (j *deliveryJournal) rotate() error {
+	if err := os.MkdirAll(j.dir, 0o750); err != nil {
+		return err
+	}
+
+	name := filepath.Join(j.dir, journalFilePrefix+j.now().UTC().Format(journalTimeLayout)+journalFileExt)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	j.file, j.size = file, info.Size()
+
+	return j.prune(filepath.Base(name))
+}
+
+// prune removes the journal files, other than current, that are older than the retention or
+// beyond the maximum number of files, oldest first.
+func (j *deliveryJournal) prune(current string) error {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && name != current && strings.HasPrefix(name, journalFilePrefix) && strings.HasSuffix(name, journalFileExt) {
+			names = append(names, name)
+		}
+	}
+	// Newest first.
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	for i, name := range names {
+		expired := false
+		if j.retention > 0 {
+			info, err := os.Stat(filepath.Join(j.dir, name))
+			if err != nil {
+				return err
+			}
+			expired = j.now().Sub(info.ModTime()) > j.retention
+		}
+		// The current file counts towards the maximum number of files.
+		if expired || (j.maxFiles > 0 && i+1 >= j.maxFiles) {
+			if err := os.Remove(filepath.Join(j.dir, name)); err != nil {
+				return err
+			}
+			_ = level.Debug(j.logger).Log("msg", "removed journal file", "file", name)
+		}
+	}
+	return nil
+}
+
+func (j *deliveryJournal) fail(err error) {
+	journalErrorsCounter.Inc()
+	_ = level.Error(j.logger).Log("msg", "failed to record the delivery in the journal", "err", fmt.Errorf("journal %s: %w", j.dir, err))
+}
+
+// deliveryResult returns the result of a delivery answered with status.
+func deliveryResult(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return deliveryResultAccepted
+	case status == http.StatusForbidden:
+		return deliveryResultRejected
+	case status == http.StatusServiceUnavailable:
+		return deliveryResultQueueFull
+	case status == http.StatusNotImplemented:
+		return deliveryResultNotImplemented
+	case status == http.StatusBadRequest:
+		return deliveryResultInvalid
+	default:
+		return deliveryResultError
+	}
+}
+
+// journalResponseWriter records the status of the response to a delivery, and the response set
+// by the handler when the status alone doesn't tell it.
+type journalResponseWriter struct {
+	http.ResponseWriter
+	status int
+	result string
+}
+
+func (w *journalResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *journalResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// setDeliveryResult sets the response to a delivery recorded in the journal.
+func setDeliveryResult(w http.ResponseWriter, result string) {
+	if journalWriter, ok := w.(*journalResponseWriter); ok {
+		journalWriter.result = result
+	}
+}
@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_RecordsDeliveries(t *testing.T) {
+	tests := []struct {
+		name     string
+		capture  bool
+		expected []string
+	}{
+		{name: "accepted deliveries", expected: []string{server.DeliveryResultAccepted, server.DeliveryResultDuplicate}},
+		{name: "capture mode", capture: true, expected: []string{server.DeliveryResultAccepted, server.DeliveryResultDuplicate, server.DeliveryResultRejected}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			dir := t.TempDir()
+			opts := server.Opts{GitHubToken: "journal-secret", DeduplicationWindow: time.Minute, JournalDir: dir, JournalCapture: tt.capture}
+			exporter, err := server.NewWorkflowMetricsExporterWithObserver(log.NewNopLogger(), opts, server.NewPrometheusObserver(prometheus.NewRegistry(), opts))
+			require.NoError(t, err)
+			ping := server.Delivery{ID: "first", Event: "ping", Body: []byte(`{"zen":"Keep it logically awesome."}`)}
+
+			// When
+			for _, secret := range []string{"journal-secret", "journal-secret", "wrong-secret"} {
+				req, err := ping.Request("/", secret)
+				require.NoError(t, err)
+				exporter.HandleGHWebHook(httptest.NewRecorder(), req)
+			}
+			require.NoError(t, exporter.Shutdown(context.Background()))
+
+			// Then
+			deliveries, err := server.ReadDeliveries(dir)
+			require.NoError(t, err)
+			require.Len(t, deliveries, len(tt.expected))
+			entries := readJournalEntries(t, dir)
+			for i, result := range tt.expected {
+				assert.Equal(t, result, entries[i].Response)
+				assert.Equal(t, "first", deliveries[i].Header("X-GitHub-Delivery"))
+				payload, err := deliveries[i].Payload()
+				require.NoError(t, err)
+				assert.Equal(t, `{"zen":"Keep it logically awesome."}`, string(payload))
+			}
+			if tt.capture {
+				assert.Equal(t, http.StatusForbidden, entries[2].Status)
+				assert.NotEmpty(t, deliveries[0].Header("X-Hub-Signature-256"))
+			} else {
+				assert.Empty(t, deliveries[0].Headers)
+			}
+		})
+	}
+}
+
+func Test_deliveryJournal_RotatesAndPrunesFiles(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	journal := server.NewDeliveryJournal(log.NewNopLogger(), server.Opts{JournalDir: dir, JournalMaxFileSize: 200, JournalMaxFiles: 3})
+	var mu sync.Mutex
+	journal.SetNow(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	})
+	header := http.Header{"X-Github-Event": {"ping"}}
+
+	// When
+	for range 10 {
+		journal.Record(header, []byte(`{"zen":"Design for failure."}`), http.StatusAccepted, "")
+	}
+	require.NoError(t, journal.Close())
+
+	// Then
+	files, err := filepath.Glob(filepath.Join(dir, server.JournalFilePrefix+"*"+server.JournalFileExt))
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(200))
+	}
+}
+
+func Test_deliveryJournal_RemovesExpiredFiles(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	expired := filepath.Join(dir, server.JournalFilePrefix+"20240101T000000.000000000Z"+server.JournalFileExt)
+	kept := filepath.Join(dir, server.JournalFilePrefix+"20240301T000000.000000000Z"+server.JournalFileExt)
+	other := filepath.Join(dir, "notes.jsonl")
+	for _, file := range []string{expired, kept, other} {
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+	}
+	require.NoError(t, os.Chtimes(expired, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+	journal := server.NewDeliveryJournal(log.NewNopLogger(), server.Opts{JournalDir: dir, JournalRetention: 24 * time.Hour})
+
+	// When
+	journal.Record(http.Header{}, []byte(`{}`), http.StatusOK, "")
+	require.NoError(t, journal.Close())
+
+	// Then
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, kept)
+	assert.FileExists(t, other)
+}
+
+func Test_deliveryJournal_DropsDeliveriesWhenTheBufferIsFull(t *testing.T) {
+	// Given a journal whose writer is not running
+	journal := server.NewStoppedDeliveryJournal(1)
+	before := testutil.ToFloat64(server.JournalErrorsCounter)
+
+	// When
+	journal.Record(http.Header{}, []byte(`{}`), http.StatusOK, "")
+	journal.Record(http.Header{}, []byte(`{}`), http.StatusOK, "")
+
+	// Then
+	assert.Equal(t, 1, journal.Buffered())
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.JournalErrorsCounter)-before)
+}
+
+func readJournalEntries(t *testing.T, dir string) []server.JournalEntry {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+server.JournalFileExt))
+	require.NoError(t, err)
+	var entries []server.JournalEntry
+	for _, file := range files {
+		buf, err := os.ReadFile(file)
+		require.NoError(t, err)
+		for _, line := range bytes.Split(bytes.TrimSpace(buf), []byte("\n")) {
+			var entry server.JournalEntry
+			require.NoError(t, json.Unmarshal(line, &entry))
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// pollerLeaseName is the lease held by the replica that polls the GitHub API.
+const pollerLeaseName = "poller"
+
+// leaderElector elects, among the replicas of the exporter, the one polling the GitHub API. The
+// leader renews its lease three times per lease duration, and steps down once it could not renew
+// it for two thirds of the lease duration, before another replica can take over.
+type leaderElector struct {
+	logger   log.Logger
+	store    sharedStore
+	identity string
+	ttl      time.Duration
+	now      func() time.Time
+
+	leading   atomic.Bool
+	renewedAt time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newLeaderElector(logger log.Logger, store sharedStore, identity string, ttl time.Duration) *leaderElector {
+	return &leaderElector{
+		logger:   logger,
+		store:    store,
+		identity: identity,
+		ttl:      ttl,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start campaigns for the lease until Stop is called.
+func (e *leaderElector) Start() {
+	e.campaign()
+	ticker := time.NewTicker(e.renewInterval())
+	go func() {
+		defer close(e.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.campaign()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops campaigning and releases the lease when held, so that another replica takes over
+// without waiting for it to expire.
+func (e *leaderElector) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		if !e.leading.Load() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+		defer cancel()
+		if err := e.store.ReleaseLease(ctx, pollerLeaseName, e.identity); err != nil {
+			_ = level.Warn(e.logger).Log("msg", "failed to release the leader lease", "err", err)
+		}
+		e.setLeading(false)
+	})
+}
+
+// renewInterval returns the interval at which the lease is renewed.
+func (e *leaderElector) renewInterval() time.Duration {
+	return e.ttl / 3
+}
+
+// IsLeader reports whether the replica holds the lease.
+func (e *leaderElector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// campaign acquires or renews the lease.
+func (e *leaderElector) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+	defer cancel()
+	acquired, err := e.store.AcquireLease(ctx, pollerLeaseName, e.identity, e.ttl)
+	if err != nil {
+		sharedStoreErrorsCounter.WithLabelValues("acquire_lease").Inc()
+		_ = level.Warn(e.logger).Log("msg", "failed to renew the leader lease", "err", err)
+		// The lease may still be held. Step down one renewal before it would expire, so that the
+		// replica stops polling before another one can acquire the lease.
+		if e.leading.Load() && e.now().Sub(e.renewedAt) >= e.ttl-e.renewInterval() {
+			e.setLeading(false)
+		}
+		return
+	}
+	if acquired {
+		e.renewedAt = e.now()
+	}
+	e.setLeading(acquired)
+}
+
+func (e *leaderElector) setLeading(leading bool) {
+	if e.leading.Swap(leading) != leading {
+		_ = level.Info(e.logger).Log("msg", "leadership changed", "identity", e.identity, "leader", leading)
+		if !leading {
+			resetPollerGauges()
+		}
+	}
+	if leading {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+type leaderElectorKey struct{}
+
+// withLeaderElector returns a context under which the pollers only poll while elector leads.
+func withLeaderElector(ctx context.Context, elector *leaderElector) context.Context {
+	return context.WithValue(ctx, leaderElectorKey{}, elector)
+}
+
+// isLeader reports whether the replica should poll: when it leads or when no leader is elected.
+func isLeader(ctx context.Context) bool {
+	elector, ok := ctx.Value(leaderElectorKey{}).(*leaderElector)
+	return !ok || elector.IsLeader()
+}
@@ -1,118 +1,651 @@
 package server
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"errors"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
 
 var (
-	workflowJobHistogramVec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "workflow_job_duration_seconds",
-		Help:    "Time that a workflow job took to reach a given state.",
-		Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
-	},
-		[]string{"org", "repo", "branch", "state", "runner_group", "workflow_name", "job_name"},
-	)
+	stateSaveErrorsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "state_save_errors_total",
+		Help: "Count of the failed saves of the persisted state.",
+	})
 
-	workflowJobDurationCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "workflow_job_duration_seconds_total",
-		Help: "The total duration of jobs.",
-	},
-		[]string{"org", "repo", "branch", "status", "conclusion", "runner_group", "workflow_name", "job_name"},
-	)
+	stateLastSaveGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "state_last_save_timestamp_seconds",
+		Help: "Unix timestamp of the last successful save of the persisted state.",
+	})
 
-	workflowJobStatusCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "workflow_job_status_count",
-		Help: "Count of workflow job events.",
+	sharedStoreErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ha_backend_errors_total",
+		Help: "Count of the failed calls to the backend shared by the replicas in HA mode, by operation.",
 	},
-		[]string{"org", "repo", "branch", "status", "conclusion", "runner_group", "workflow_name", "job_name"},
+		[]string{"operation"},
 	)
 
-	workflowRunHistogramVec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "workflow_execution_time_seconds",
-		Help:    "Time that a workflow took to run.",
-		Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
-	},
-		[]string{"org", "repo", "branch", "workflow_name", "conclusion"},
-	)
+	leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ha_leader",
+		Help: "Whether the replica is the leader polling the GitHub API in HA mode.",
+	})
 
-	workflowRunStatusCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "workflow_status_count",
-		Help: "Count of the occurrences of different workflow states.",
+	journalErrorsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_journal_errors_total",
+		Help: "Count of webhook deliveries that could not be recorded in the journal.",
+	})
+
+	reconciledEventsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workflow_reconciled_events_total",
+		Help: "Count of workflow run and job completions recovered by the reconciler because their delivery was missed.",
 	},
-		[]string{"org", "repo", "branch", "status", "conclusion", "workflow_name"},
+		[]string{"event"},
 	)
 
 	totalMinutesUsedActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "actions_total_minutes_used_minutes",
 		Help: "Total minutes used for the GitHub Actions.",
 	},
-		[]string{"org", "user"},
+		[]string{"org", "user", "enterprise"},
 	)
 
 	includedMinutesUsedActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "actions_included_minutes",
 		Help: "Included Minutes for the GitHub Actions.",
 	},
-		[]string{"org", "user"},
+		[]string{"org", "user", "enterprise"},
 	)
 
 	totalPaidMinutesActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "actions_total_paid_minutes",
 		Help: "Paid Minutes for the GitHub Actions.",
 	},
-		[]string{"org", "user"},
+		[]string{"org", "user", "enterprise"},
 	)
 
 	totalMinutesUsedByHostTypeActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "actions_total_minutes_used_by_host_minutes",
 		Help: "Total minutes used for a specific host type for the GitHub Actions.",
 	},
-		[]string{"org", "user", "host_type"},
+		[]string{"org", "user", "enterprise", "host_type"},
+	)
+
+	usageMinutesActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_usage_minutes",
+		Help: "Minutes of a GitHub Actions SKU used by a repository in the current month, from the usage report.",
+	},
+		[]string{"org", "user", "enterprise", "repo", "sku"},
+	)
+
+	usageGrossAmountActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_usage_gross_amount_dollars",
+		Help: "Gross amount of a GitHub Actions SKU used by a repository in the current month, from the usage report.",
+	},
+		[]string{"org", "user", "enterprise", "repo", "sku"},
+	)
+
+	usageNetAmountActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_usage_net_amount_dollars",
+		Help: "Net amount billed for a GitHub Actions SKU used by a repository in the current month, from the usage report.",
+	},
+		[]string{"org", "user", "enterprise", "repo", "sku"},
+	)
+
+	runnerOnlineGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "runner_online",
+		Help: "Whether a self-hosted runner is online (1) or offline (0).",
+	},
+		[]string{"org", "repo", "enterprise", "runner_group", "runner", "os", "labels"},
+	)
+
+	runnerBusyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "runner_busy",
+		Help: "Whether a self-hosted runner is running a job (1) or not (0).",
+	},
+		[]string{"org", "repo", "enterprise", "runner_group", "runner", "os", "labels"},
+	)
+
+	runnersByLabelsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "runners_by_labels",
+		Help: "Number of self-hosted runners with a set of labels that are idle, busy or offline.",
+	},
+		[]string{"org", "repo", "enterprise", "runner_group", "labels", "status"},
+	)
+
+	runnersByGroupGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "runners_by_group",
+		Help: "Number of self-hosted runners of a runner group that are idle, busy or offline.",
+	},
+		[]string{"org", "repo", "enterprise", "runner_group", "status"},
+	)
+
+	cacheOrgCountGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_cache_org_active_caches",
+		Help: "Number of active actions caches of an organization.",
+	},
+		[]string{"org"},
+	)
+
+	cacheOrgSizeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_cache_org_active_size_bytes",
+		Help: "Size of the active actions caches of an organization.",
+	},
+		[]string{"org"},
+	)
+
+	cacheRepoCountGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_cache_repo_active_caches",
+		Help: "Number of active actions caches of a repository.",
+	},
+		[]string{"org", "repo"},
+	)
+
+	cacheRepoSizeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_cache_repo_active_size_bytes",
+		Help: "Size of the active actions caches of a repository.",
+	},
+		[]string{"org", "repo"},
+	)
+
+	cacheKeySizeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_cache_key_size_bytes",
+		Help: "Size of one of the largest actions cache keys of a repository.",
+	},
+		[]string{"org", "repo", "key", "ref"},
+	)
+
+	githubAPIRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_api_requests_total",
+		Help: "Count of GitHub API requests by endpoint and response status code.",
+	},
+		[]string{"endpoint", "code"},
+	)
+
+	githubAPIRequestDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "github_api_request_duration_seconds",
+		Help:    "Time that a GitHub API request took.",
+		Buckets: prometheus.DefBuckets,
+	},
+		[]string{"endpoint"},
+	)
+
+	githubAPIRateLimitRemainingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_api_rate_limit_remaining",
+		Help: "Number of GitHub API requests remaining in the current rate limit window of a token.",
+	},
+		[]string{"token", "resource"},
+	)
+
+	githubAPIRateLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_api_rate_limit_limit",
+		Help: "Maximum number of GitHub API requests of a token per rate limit window.",
+	},
+		[]string{"token", "resource"},
+	)
+
+	githubAPIRateLimitResetGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_api_rate_limit_reset_timestamp_seconds",
+		Help: "Time at which the current rate limit window of a token resets.",
+	},
+		[]string{"token", "resource"},
+	)
+
+	githubAPIRateLimitBackoffsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_api_rate_limit_backoffs_total",
+		Help: "Count of GitHub API requests held back until the rate limit of a token is reset.",
+	},
+		[]string{"token"},
+	)
+
+	pollerLastSuccessGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_api_poller_last_success_timestamp_seconds",
+		Help: "Time of the last successful poll of a GitHub API poller.",
+	},
+		[]string{"poller", "target"},
+	)
+
+	pollerErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_api_poller_errors_total",
+		Help: "Count of failed polls of a GitHub API poller.",
+	},
+		[]string{"poller", "target"},
+	)
+
+	totalMinutesUsedByOrgActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_total_minutes_used_by_org_minutes",
+		Help: "Total minutes used for the GitHub Actions of an organization of an enterprise in the current month.",
+	},
+		[]string{"enterprise", "org"},
+	)
+
+	netAmountByOrgActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "actions_net_amount_by_org_dollars",
+		Help: "Net amount billed for the GitHub Actions of an organization of an enterprise in the current month.",
+	},
+		[]string{"enterprise", "org"},
 	)
 )
 
 func init() {
 	// Register metrics with prometheus
-	prometheus.MustRegister(workflowJobHistogramVec)
-	prometheus.MustRegister(workflowJobStatusCounter)
-	prometheus.MustRegister(workflowJobDurationCounter)
-	prometheus.MustRegister(workflowRunHistogramVec)
-	prometheus.MustRegister(workflowRunStatusCounter)
+	defaultDeliveryMetrics = newDeliveryMetrics(prometheus.DefaultRegisterer)
+	prometheus.MustRegister(reconciledEventsCounter)
+	prometheus.MustRegister(journalErrorsCounter)
+	prometheus.MustRegister(sharedStoreErrorsCounter)
+	prometheus.MustRegister(leaderGauge)
+	prometheus.MustRegister(stateSaveErrorsCounter)
+	prometheus.MustRegister(stateLastSaveGauge)
 	prometheus.MustRegister(totalMinutesUsedActions)
 	prometheus.MustRegister(includedMinutesUsedActions)
 	prometheus.MustRegister(totalPaidMinutesActions)
 	prometheus.MustRegister(totalMinutesUsedByHostTypeActions)
+	prometheus.MustRegister(totalMinutesUsedByOrgActions)
+	prometheus.MustRegister(netAmountByOrgActions)
+	prometheus.MustRegister(usageMinutesActions)
+	prometheus.MustRegister(usageGrossAmountActions)
+	prometheus.MustRegister(usageNetAmountActions)
+	prometheus.MustRegister(runnerOnlineGauge)
+	prometheus.MustRegister(runnerBusyGauge)
+	prometheus.MustRegister(runnersByLabelsGauge)
+	prometheus.MustRegister(runnersByGroupGauge)
+	prometheus.MustRegister(cacheOrgCountGauge)
+	prometheus.MustRegister(cacheOrgSizeGauge)
+	prometheus.MustRegister(cacheRepoCountGauge)
+	prometheus.MustRegister(cacheRepoSizeGauge)
+	prometheus.MustRegister(cacheKeySizeGauge)
+	prometheus.MustRegister(githubAPIRequestsCounter)
+	prometheus.MustRegister(githubAPIRequestDurationHistogram)
+	prometheus.MustRegister(githubAPIRateLimitRemainingGauge)
+	prometheus.MustRegister(githubAPIRateLimitGauge)
+	prometheus.MustRegister(githubAPIRateLimitResetGauge)
+	prometheus.MustRegister(githubAPIRateLimitBackoffsCounter)
+	prometheus.MustRegister(pollerLastSuccessGauge)
+	prometheus.MustRegister(pollerErrorsCounter)
+}
+
+// resetPollerGauges deletes the series of the gauges set from the GitHub API, so that a replica
+// that stopped polling doesn't keep exporting stale values next to the ones of the leader.
+func resetPollerGauges() {
+	for _, gauge := range []*prometheus.GaugeVec{
+		totalMinutesUsedActions,
+		includedMinutesUsedActions,
+		totalPaidMinutesActions,
+		totalMinutesUsedByHostTypeActions,
+		totalMinutesUsedByOrgActions,
+		netAmountByOrgActions,
+		usageMinutesActions,
+		usageGrossAmountActions,
+		usageNetAmountActions,
+		runnerOnlineGauge,
+		runnerBusyGauge,
+		runnersByLabelsGauge,
+		runnersByGroupGauge,
+		cacheOrgCountGauge,
+		cacheOrgSizeGauge,
+		cacheRepoCountGauge,
+		cacheRepoSizeGauge,
+		cacheKeySizeGauge,
+		githubAPIRateLimitRemainingGauge,
+		githubAPIRateLimitGauge,
+		githubAPIRateLimitResetGauge,
+		pollerLastSuccessGauge,
+	} {
+		gauge.Reset()
+	}
+}
+
+// deliveryMetrics are the metrics of the handling of the webhook deliveries, up to the jobs they
+// keep track of. Like the workflow metrics, they are registered with the registry of the
+// observer of an exporter, so that a replay in process exports them too.
+type deliveryMetrics struct {
+	jobsQueued          *prometheus.GaugeVec
+	jobsInProgress      *prometheus.GaugeVec
+	jobsExpired         *prometheus.CounterVec
+	secretValidations   *prometheus.CounterVec
+	duplicateDeliveries *prometheus.CounterVec
+	queueDepth          prometheus.Gauge
+	queueCapacity       prometheus.Gauge
+	queueWait           *prometheus.HistogramVec
+	queueRejected       *prometheus.CounterVec
+}
+
+// defaultDeliveryMetrics are the delivery metrics registered with the default registry.
+var defaultDeliveryMetrics *deliveryMetrics
+
+// newDeliveryMetrics returns the delivery metrics registered with reg.
+func newDeliveryMetrics(reg prometheus.Registerer) *deliveryMetrics {
+	return &deliveryMetrics{
+		jobsQueued: mustRegisterOrExisting(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "workflow_jobs_queued",
+			Help: "Number of workflow jobs currently queued.",
+		},
+			[]string{"org", "repo", "runner_group", "runner_labels"},
+		)),
+		jobsInProgress: mustRegisterOrExisting(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "workflow_jobs_in_progress",
+			Help: "Number of workflow jobs currently running.",
+		},
+			[]string{"org", "repo", "runner_group", "runner_labels"},
+		)),
+		jobsExpired: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "workflow_jobs_expired_total",
+			Help: "Count of queued or running workflow jobs that stopped being tracked because no update was received in time.",
+		},
+			[]string{"state"},
+		)),
+		secretValidations: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_secret_validations_total",
+			Help: "Count of webhook deliveries validated, by delivery target and the index of the secret that matched the signature.",
+		},
+			[]string{"target", "secret_index"},
+		)),
+		duplicateDeliveries: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "duplicate_deliveries_total",
+			Help: "Count of webhook deliveries dropped because they were already processed.",
+		},
+			[]string{"event", "reason"},
+		)),
+		queueDepth: mustRegisterOrExisting(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhook_queue_depth",
+			Help: "Number of webhook events waiting to be processed.",
+		})),
+		queueCapacity: mustRegisterOrExisting(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhook_queue_capacity",
+			Help: "Maximum number of webhook events that can wait to be processed.",
+		})),
+		queueWait: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "webhook_queue_wait_seconds",
+			Help:    "Time that a webhook event waited in the queue before being processed.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+		},
+			[]string{"event"},
+		)),
+		queueRejected: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_queue_rejected_total",
+			Help: "Count of webhook events rejected because the queue was full.",
+		},
+			[]string{"event"},
+		)),
+	}
+}
+
+// inFlightJobs returns the gauge counting the workflow jobs in the given state.
+func (m *deliveryMetrics) inFlightJobs(state string) *prometheus.GaugeVec {
+	if state == jobStateInProgress {
+		return m.jobsInProgress
+	}
+	return m.jobsQueued
 }
 
 type WorkflowObserver interface {
-	ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, workflowName, jobName string, seconds float64)
-	CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string)
-	CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string, seconds float64)
+	ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64)
+	CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string)
+	CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64)
+
+	ObserveWorkflowJobStepDuration(org, repo, workflowName, jobName, stepName, conclusion string, seconds float64)
+	CountWorkflowJobStepConclusion(org, repo, workflowName, jobName, stepName, conclusion string)
 
 	ObserveWorkflowRunDuration(org, repo, branch, workflow, conclusion string, seconds float64)
 	CountWorkflowRunStatus(org, repo, branch, status, conclusion, workflow string)
+
+	ObserveCheckRunDuration(org, repo, branch, app, checkName, conclusion string, seconds float64)
+	CountCheckRunStatus(org, repo, branch, status, conclusion, app, checkName string)
+
+	ObserveCheckSuiteDuration(org, repo, branch, app, conclusion string, seconds float64)
+	CountCheckSuiteStatus(org, repo, branch, status, conclusion, app string)
 }
 
 var _ WorkflowObserver = (*PrometheusObserver)(nil)
 
-type PrometheusObserver struct{}
+// PrometheusObserver exports the observations as Prometheus metrics. The metrics are owned by the
+// observer instead of being registered up front, as the workflow job metrics are labelled by
+// runner labels and runner name only when configured, and so that the observations can be
+// exported to another registry, e.g. when backfilling.
+type PrometheusObserver struct {
+	withRunnerLabels bool
+	withRunnerName   bool
+
+	// delivery are the delivery metrics registered with the same registry.
+	delivery *deliveryMetrics
+
+	workflowJobHistogramVec    *prometheus.HistogramVec
+	workflowJobDurationCounter *prometheus.CounterVec
+	workflowJobStatusCounter   *prometheus.CounterVec
 
-func (o *PrometheusObserver) ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, workflowName, jobName string, seconds float64) {
-	workflowJobHistogramVec.WithLabelValues(org, repo, branch, state, runnerGroup, workflowName, jobName).
+	workflowJobStepHistogramVec      *prometheus.HistogramVec
+	workflowJobStepConclusionCounter *prometheus.CounterVec
+	workflowRunHistogramVec          *prometheus.HistogramVec
+	workflowRunStatusCounter         *prometheus.CounterVec
+	checkRunHistogramVec             *prometheus.HistogramVec
+	checkRunStatusCounter            *prometheus.CounterVec
+	checkSuiteHistogramVec           *prometheus.HistogramVec
+	checkSuiteStatusCounter          *prometheus.CounterVec
+}
+
+// NewPrometheusObserver returns an observer whose metrics are registered with reg.
+func NewPrometheusObserver(reg prometheus.Registerer, opts Opts) *PrometheusObserver {
+	var extraLabels []string
+	if opts.WorkflowJobRunnerLabels {
+		extraLabels = append(extraLabels, "runner_labels")
+	}
+	if opts.WorkflowJobRunnerName {
+		extraLabels = append(extraLabels, "runner_name")
+	}
+
+	return &PrometheusObserver{
+		withRunnerLabels: opts.WorkflowJobRunnerLabels,
+		withRunnerName:   opts.WorkflowJobRunnerName,
+		delivery:         newDeliveryMetrics(reg),
+		workflowJobHistogramVec: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "workflow_job_duration_seconds",
+			Help:    "Time that a workflow job took to reach a given state.",
+			Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
+		},
+			append([]string{"org", "repo", "branch", "state", "runner_group", "workflow_name", "job_name"}, extraLabels...),
+		)),
+		workflowJobDurationCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "workflow_job_duration_seconds_total",
+			Help: "The total duration of jobs.",
+		},
+			append([]string{"org", "repo", "branch", "status", "conclusion", "runner_group", "workflow_name", "job_name"}, extraLabels...),
+		)),
+		workflowJobStatusCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "workflow_job_status_count",
+			Help: "Count of workflow job events.",
+		},
+			append([]string{"org", "repo", "branch", "status", "conclusion", "runner_group", "workflow_name", "job_name"}, extraLabels...),
+		)),
+		workflowJobStepHistogramVec: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "workflow_job_step_duration_seconds",
+			Help:    "Time that a step of a workflow job took to run.",
+			Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
+		},
+			[]string{"org", "repo", "workflow_name", "job_name", "step_name", "conclusion"},
+		)),
+		workflowJobStepConclusionCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "workflow_job_step_conclusion_count",
+			Help: "Count of the conclusions of workflow job steps.",
+		},
+			[]string{"org", "repo", "workflow_name", "job_name", "step_name", "conclusion"},
+		)),
+		workflowRunHistogramVec: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "workflow_execution_time_seconds",
+			Help:    "Time that a workflow took to run.",
+			Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
+		},
+			[]string{"org", "repo", "branch", "workflow_name", "conclusion"},
+		)),
+		workflowRunStatusCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "workflow_status_count",
+			Help: "Count of the occurrences of different workflow states.",
+		},
+			[]string{"org", "repo", "branch", "status", "conclusion", "workflow_name"},
+		)),
+		checkRunHistogramVec: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "check_run_duration_seconds",
+			Help:    "Time that a check run took to complete.",
+			Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
+		},
+			[]string{"org", "repo", "branch", "app", "check_name", "conclusion"},
+		)),
+		checkRunStatusCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_run_status_count",
+			Help: "Count of check run events.",
+		},
+			[]string{"org", "repo", "branch", "status", "conclusion", "app", "check_name"},
+		)),
+		checkSuiteHistogramVec: mustRegisterOrExisting(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "check_suite_duration_seconds",
+			Help:    "Time that a check suite took to complete.",
+			Buckets: prometheus.ExponentialBuckets(1, 1.4, 30),
+		},
+			[]string{"org", "repo", "branch", "app", "conclusion"},
+		)),
+		checkSuiteStatusCounter: mustRegisterOrExisting(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "check_suite_status_count",
+			Help: "Count of check suite events.",
+		},
+			[]string{"org", "repo", "branch", "status", "conclusion", "app"},
+		)),
+	}
+}
+
+// mustRegisterOrExisting registers the collector, or returns the identical collector registered before.
+func mustRegisterOrExisting[T prometheus.Collector](reg prometheus.Registerer, collector T) T {
+	if err := reg.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector.(T)
+		}
+		panic(err)
+	}
+	return collector
+}
+
+// jobLabelValues appends the configured runner dimensions to the label values of a workflow job metric.
+func (o *PrometheusObserver) jobLabelValues(runnerLabels, runnerName string, values ...string) []string {
+	if o.withRunnerLabels {
+		values = append(values, runnerLabels)
+	}
+	if o.withRunnerName {
+		values = append(values, runnerName)
+	}
+	return values
+}
+
+func (o *PrometheusObserver) ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64) {
+	o.workflowJobHistogramVec.WithLabelValues(o.jobLabelValues(runnerLabels, runnerName, org, repo, branch, state, runnerGroup, workflowName, jobName)...).
 		Observe(seconds)
 }
 
-func (o *PrometheusObserver) CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string) {
-	workflowJobStatusCounter.WithLabelValues(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName).Inc()
+func (o *PrometheusObserver) CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string) {
+	o.workflowJobStatusCounter.WithLabelValues(o.jobLabelValues(runnerLabels, runnerName, org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName)...).Inc()
+}
+
+func (o *PrometheusObserver) CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64) {
+	o.workflowJobDurationCounter.WithLabelValues(o.jobLabelValues(runnerLabels, runnerName, org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName)...).Add(seconds)
 }
 
-func (o *PrometheusObserver) CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string, seconds float64) {
-	workflowJobDurationCounter.WithLabelValues(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName).Add(seconds)
+func (o *PrometheusObserver) ObserveWorkflowJobStepDuration(org, repo, workflowName, jobName, stepName, conclusion string, seconds float64) {
+	o.workflowJobStepHistogramVec.WithLabelValues(org, repo, workflowName, jobName, stepName, conclusion).Observe(seconds)
+}
+
+func (o *PrometheusObserver) CountWorkflowJobStepConclusion(org, repo, workflowName, jobName, stepName, conclusion string) {
+	o.workflowJobStepConclusionCounter.WithLabelValues(org, repo, workflowName, jobName, stepName, conclusion).Inc()
 }
 
 func (o *PrometheusObserver) ObserveWorkflowRunDuration(org, repo, branch, workflowName, conclusion string, seconds float64) {
-	workflowRunHistogramVec.WithLabelValues(org, repo, branch, workflowName, conclusion).
+	o.workflowRunHistogramVec.WithLabelValues(org, repo, branch, workflowName, conclusion).
 		Observe(seconds)
 }
 
 func (o *PrometheusObserver) CountWorkflowRunStatus(org, repo, branch, status, conclusion, workflowName string) {
-	workflowRunStatusCounter.WithLabelValues(org, repo, branch, status, conclusion, workflowName).Inc()
+	o.workflowRunStatusCounter.WithLabelValues(org, repo, branch, status, conclusion, workflowName).Inc()
+}
+
+func (o *PrometheusObserver) ObserveCheckRunDuration(org, repo, branch, app, checkName, conclusion string, seconds float64) {
+	o.checkRunHistogramVec.WithLabelValues(org, repo, branch, app, checkName, conclusion).Observe(seconds)
+}
+
+func (o *PrometheusObserver) CountCheckRunStatus(org, repo, branch, status, conclusion, app, checkName string) {
+	o.checkRunStatusCounter.WithLabelValues(org, repo, branch, status, conclusion, app, checkName).Inc()
+}
+
+func (o *PrometheusObserver) ObserveCheckSuiteDuration(org, repo, branch, app, conclusion string, seconds float64) {
+	o.checkSuiteHistogramVec.WithLabelValues(org, repo, branch, app, conclusion).Observe(seconds)
+}
+
+func (o *PrometheusObserver) CountCheckSuiteStatus(org, repo, branch, status, conclusion, app string) {
+	o.checkSuiteStatusCounter.WithLabelValues(org, repo, branch, status, conclusion, app).Inc()
+}
+
+// counterSample is the value of a counter series, as saved in the state of the exporter.
+type counterSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// counters returns the counters of the observer by metric name.
+func (o *PrometheusObserver) counters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		"workflow_job_duration_seconds_total": o.workflowJobDurationCounter,
+		"workflow_job_status_count":           o.workflowJobStatusCounter,
+		"workflow_job_step_conclusion_count":  o.workflowJobStepConclusionCounter,
+		"workflow_status_count":               o.workflowRunStatusCounter,
+		"check_run_status_count":              o.checkRunStatusCounter,
+		"check_suite_status_count":            o.checkSuiteStatusCounter,
+	}
+}
+
+// snapshotCounters returns the values of the counters of the observer.
+func (o *PrometheusObserver) snapshotCounters() ([]counterSample, error) {
+	var samples []counterSample
+	for name, counter := range o.counters() {
+		metrics := make(chan prometheus.Metric)
+		go func() {
+			counter.Collect(metrics)
+			close(metrics)
+		}()
+		var err error
+		for metric := range metrics {
+			m := &dto.Metric{}
+			if writeErr := metric.Write(m); writeErr != nil {
+				err = writeErr
+				continue
+			}
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			samples = append(samples, counterSample{Name: name, Labels: labels, Value: m.GetCounter().GetValue()})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+	return samples, nil
+}
+
+// restoreCounters adds the saved values to the counters of the observer. The samples of unknown
+// counters, or whose labels don't match the configured ones, are skipped and counted.
+func (o *PrometheusObserver) restoreCounters(samples []counterSample) int {
+	counters := o.counters()
+	var skipped int
+	for _, sample := range samples {
+		counter, ok := counters[sample.Name]
+		if !ok {
+			skipped++
+			continue
+		}
+		series, err := counter.GetMetricWith(sample.Labels)
+		if err != nil || sample.Value < 0 {
+			skipped++
+			continue
+		}
+		series.Add(sample.Value)
+	}
+	return skipped
 }
@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PrometheusObserver_WorkflowJobLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     server.Opts
+		expected string
+	}{
+		{
+			name:     "without runner dimensions",
+			expected: `workflow_job_status_count{branch="main",conclusion="success",job_name="Test",org="someone",repo="some-repo",runner_group="gpu-pool",status="completed",workflow_name="Build"} 1`,
+		},
+		{
+			name:     "with runner labels",
+			opts:     server.Opts{WorkflowJobRunnerLabels: true},
+			expected: `workflow_job_status_count{branch="main",conclusion="success",job_name="Test",org="someone",repo="some-repo",runner_group="gpu-pool",runner_labels="gpu,linux",status="completed",workflow_name="Build"} 1`,
+		},
+		{
+			name:     "with runner labels and name",
+			opts:     server.Opts{WorkflowJobRunnerLabels: true, WorkflowJobRunnerName: true},
+			expected: `workflow_job_status_count{branch="main",conclusion="success",job_name="Test",org="someone",repo="some-repo",runner_group="gpu-pool",runner_labels="gpu,linux",runner_name="runner-1",status="completed",workflow_name="Build"} 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			observer := server.NewPrometheusObserver(reg, tt.opts)
+
+			observer.CountWorkflowJobStatus("someone", "some-repo", "main", "completed", "success", "gpu-pool", "gpu,linux", "runner-1", "Build", "Test")
+
+			expected := "# HELP workflow_job_status_count Count of workflow job events.\n# TYPE workflow_job_status_count counter\n" + tt.expected + "\n"
+			require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "workflow_job_status_count"))
+		})
+	}
+}
+
+func Test_PrometheusObserver_ReusesRegisteredMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first := server.NewPrometheusObserver(reg, server.Opts{})
+	second := server.NewPrometheusObserver(reg, server.Opts{})
+
+	first.CountWorkflowJobStatus("someone", "some-repo", "main", "completed", "success", "", "", "", "Build", "Test")
+	second.CountWorkflowJobStatus("someone", "some-repo", "main", "completed", "success", "", "", "", "Build", "Test")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(first.WorkflowJobStatusCounter()))
+}
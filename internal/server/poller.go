@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// poll calls collect every interval until ctx is done, skipping the polls while another replica
+// leads. The outcome of each poll is reported by the poller health metrics under the given poller
+// and target names.
+func poll(ctx context.Context, poller, target string, interval time.Duration, collect func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !isLeader(ctx) {
+				continue
+			}
+			err := collect(ctx)
+			if !isLeader(ctx) {
+				// The replica stepped down while polling, the gauges it just set are stale.
+				resetPollerGauges()
+				continue
+			}
+			if err != nil {
+				pollerErrorsCounter.WithLabelValues(poller, target).Inc()
+				continue
+			}
+			pollerLastSuccessGauge.WithLabelValues(poller, target).SetToCurrentTime()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// reconcileSettleDelay leaves the webhook deliveries of a completed workflow run some time
+	// to arrive before the run is reconciled.
+	reconcileSettleDelay = 2 * time.Minute
+
+	reconciledRunsMaxEntries = 100000
+)
+
+// WorkflowReconciler recovers the completions of workflow runs and jobs whose webhook deliveries
+// were missed, e.g. while the exporter was down, by listing the recently completed workflow runs
+// and their jobs with the GitHub API.
+type WorkflowReconciler struct {
+	GHClients *GitHubClients
+	Logger    log.Logger
+	Opts      Opts
+	Exporter  *WorkflowMetricsExporter
+
+	// reconciledRuns holds the workflow run attempts whose jobs were all reconciled.
+	reconciledRuns *expiringSet
+}
+
+// reconcileTarget is a repository whose workflow runs are reconciled.
+type reconcileTarget struct {
+	owner, repo string
+}
+
+func NewWorkflowReconciler(logger log.Logger, opts Opts, clients *GitHubClients, exporter *WorkflowMetricsExporter) *WorkflowReconciler {
+	return &WorkflowReconciler{
+		GHClients:      clients,
+		Logger:         logger,
+		Opts:           opts,
+		Exporter:       exporter,
+		reconciledRuns: newExpiringSet(2*opts.ReconcileLookback, reconciledRunsMaxEntries),
+	}
+}
+
+// StartReconciler reconciles the workflow runs of every configured repository, each one on its own.
+func (c *WorkflowReconciler) StartReconciler(ctx context.Context) error {
+	if c.Opts.ReconcileInterval <= 0 {
+		return errors.New("reconcile interval not configured")
+	}
+	if c.Opts.ReconcileLookback <= 0 {
+		return errors.New("reconcile lookback not configured")
+	}
+	if !c.GHClients.Enabled() {
+		return errors.New("github credentials not configured")
+	}
+
+	targets, err := c.targets()
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("repository not configured")
+	}
+
+	for _, target := range targets {
+		go func(target reconcileTarget) {
+			poll(ctx, "reconcile", "repo:"+target.owner+"/"+target.repo, c.Opts.ReconcileInterval, func(ctx context.Context) error {
+				return c.reconcile(ctx, target)
+			})
+			_ = level.Info(c.Logger).Log("msg", "stopped reconciling workflow runs", "org", target.owner, "repo", target.repo)
+		}(target)
+	}
+
+	return nil
+}
+
+func (c *WorkflowReconciler) targets() ([]reconcileTarget, error) {
+	var targets []reconcileTarget
+	for _, repository := range c.Opts.ReconcileRepositories {
+		owner, repo, found := strings.Cut(repository, "/")
+		if !found || owner == "" || repo == "" {
+			return nil, fmt.Errorf("invalid reconcile repository %q, expected <owner>/<repo>", repository)
+		}
+		targets = append(targets, reconcileTarget{owner: owner, repo: repo})
+	}
+	return targets, nil
+}
+
+// reconcile collects the completions of the completed workflow runs of a repository created within
+// the lookback, and of their jobs, that were not collected yet.
+func (c *WorkflowReconciler) reconcile(ctx context.Context, target reconcileTarget) error {
+	client, err := c.GHClients.ForRepository(ctx, target.owner, target.repo)
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to reconcile the workflow runs", "org", target.owner, "repo", target.repo, "err", err)
+		return err
+	}
+
+	now := time.Now()
+	since := now.Add(-c.Opts.ReconcileLookback)
+	runs, err := listPages(func(opts github.ListOptions) ([]*github.WorkflowRun, *github.Response, error) {
+		runs, res, err := client.Actions.ListRepositoryWorkflowRuns(ctx, target.owner, target.repo, &github.ListWorkflowRunsOptions{
+			Status:      "completed",
+			Created:     ">=" + since.UTC().Format(time.RFC3339),
+			ListOptions: opts,
+		})
+		if runs == nil {
+			return nil, res, err
+		}
+		return runs.WorkflowRuns, res, err
+	})
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to list the workflow runs", "org", target.owner, "repo", target.repo, "err", err)
+		return fmt.Errorf("listing workflow runs: %w", err)
+	}
+
+	for _, run := range runs {
+		key := workflowRunCompletionKey(run)
+		if run.GetStatus() != "completed" || run.GetUpdatedAt().Time.After(now.Add(-reconcileSettleDelay)) || c.reconciledRuns.Contains(key) {
+			continue
+		}
+
+		if err := c.reconcileJobs(ctx, client, target, run); err != nil {
+			_ = c.Logger.Log("msg", "failed to reconcile the workflow jobs", "org", target.owner, "repo", target.repo, "runId", run.GetID(), "err", err)
+			return err
+		}
+
+		// The completion is counted as recovered only when it was not collected from a delivery
+		// in the meantime.
+		if !c.Exporter.completionCollected(key) && c.Exporter.CollectWorkflowRunEvent(&github.WorkflowRunEvent{
+			Action:      github.String("completed"),
+			WorkflowRun: run,
+			Workflow:    &github.Workflow{ID: run.WorkflowID, Name: run.Name},
+			Repo:        run.GetRepository(),
+		}) {
+			_ = level.Info(c.Logger).Log("msg", "recovered missed workflow run completion", "org", target.owner, "repo", target.repo, "runId", run.GetID())
+			reconciledEventsCounter.WithLabelValues("workflow_run").Inc()
+		}
+		c.reconciledRuns.Add(key)
+	}
+
+	return nil
+}
+
+// reconcileJobs collects the completions of the jobs of a workflow run attempt that were not
+// collected yet.
+func (c *WorkflowReconciler) reconcileJobs(ctx context.Context, client *github.Client, target reconcileTarget, run *github.WorkflowRun) error {
+	jobs, err := listPages(func(opts github.ListOptions) ([]*github.WorkflowJob, *github.Response, error) {
+		jobs, res, err := client.Actions.ListWorkflowJobsAttempt(ctx, target.owner, target.repo, run.GetID(), int64(run.GetRunAttempt()), &opts)
+		if jobs == nil {
+			return nil, res, err
+		}
+		return jobs.Jobs, res, err
+	})
+	if err != nil {
+		return fmt.Errorf("listing workflow jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if job.GetStatus() != "completed" || c.Exporter.completionCollected(workflowJobCompletionKey(job)) {
+			continue
+		}
+
+		if !c.Exporter.CollectWorkflowJobEvent(&github.WorkflowJobEvent{
+			Action:      github.String("completed"),
+			WorkflowJob: job,
+			Repo:        run.GetRepository(),
+		}) {
+			continue
+		}
+		_ = level.Info(c.Logger).Log("msg", "recovered missed workflow job completion", "org", target.owner, "repo", target.repo, "runId", run.GetID(), "jobId", job.GetID())
+		reconciledEventsCounter.WithLabelValues("workflow_job").Inc()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WorkflowReconciler_RecoversMissedCompletions(t *testing.T) {
+	// Given
+	completedAt := time.Now().Add(-10 * time.Minute)
+	repository := &github.Repository{Name: github.String("reconcile-repo"), Owner: &github.User{Login: github.String("reconcile-org")}}
+	run := func(id int64, updatedAt time.Time) *github.WorkflowRun {
+		return &github.WorkflowRun{
+			ID: github.Int64(id), Name: github.String("Build and test"), RunAttempt: github.Int(1),
+			HeadBranch: github.String("main"), Status: github.String("completed"), Conclusion: github.String("success"),
+			RunStartedAt: &github.Timestamp{Time: updatedAt.Add(-time.Minute)}, UpdatedAt: &github.Timestamp{Time: updatedAt},
+			Repository: repository,
+		}
+	}
+	job := func(id, runID int64) *github.WorkflowJob {
+		return &github.WorkflowJob{
+			ID: github.Int64(id), RunID: github.Int64(runID), Name: github.String("Test"), WorkflowName: github.String("Build and test"),
+			HeadBranch: github.String("main"), Status: github.String("completed"), Conclusion: github.String("success"),
+			StartedAt: &github.Timestamp{Time: completedAt.Add(-time.Minute)}, CompletedAt: &github.Timestamp{Time: completedAt},
+		}
+	}
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/repos/reconcile-org/reconcile-repo/actions/runs": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "completed", r.URL.Query().Get("status"))
+			_ = json.NewEncoder(w).Encode(github.WorkflowRuns{WorkflowRuns: []*github.WorkflowRun{
+				run(1, completedAt),
+				run(2, completedAt),
+				run(3, time.Now()),
+			}})
+		},
+		"/repos/reconcile-org/reconcile-repo/actions/runs/1/attempts/1/jobs": respondJSON(github.Jobs{Jobs: []*github.WorkflowJob{job(11, 1), job(12, 1)}}),
+		"/repos/reconcile-org/reconcile-repo/actions/runs/2/attempts/1/jobs": respondJSON(github.Jobs{Jobs: []*github.WorkflowJob{job(21, 2)}}),
+	})
+	opts.ReconcileInterval = time.Minute
+	opts.ReconcileRepositories = []string{"reconcile-org/reconcile-repo"}
+	opts.ReconcileLookback = time.Hour
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	observer := server.NewPrometheusObserver(prometheus.NewRegistry(), opts)
+	exporter := &server.WorkflowMetricsExporter{Logger: log.NewNopLogger(), Opts: opts, PrometheusObserver: observer}
+	exporter.TrackCompletions(time.Hour)
+	reconciler := server.NewWorkflowReconciler(log.NewNopLogger(), opts, clients, exporter)
+	jobCounter := observer.WorkflowJobStatusCounter().WithLabelValues("reconcile-org", "reconcile-repo", "main", "completed", "success", "", "Build and test", "Test")
+	runCounter := observer.WorkflowRunStatusCounter().WithLabelValues("reconcile-org", "reconcile-repo", "main", "completed", "success", "Build and test")
+
+	// The completions of run 2 and job 11 were delivered.
+	exporter.CollectWorkflowJobEvent(&github.WorkflowJobEvent{Action: github.String("completed"), WorkflowJob: job(11, 1), Repo: repository})
+	exporter.CollectWorkflowRunEvent(&github.WorkflowRunEvent{Action: github.String("completed"), WorkflowRun: run(2, completedAt), Workflow: &github.Workflow{Name: github.String("Build and test")}, Repo: repository})
+
+	// When
+	targets, err := reconciler.Targets()
+	require.NoError(t, err)
+	require.NoError(t, reconciler.Reconcile(context.Background(), targets[0]))
+	require.NoError(t, reconciler.Reconcile(context.Background(), targets[0]))
+	exporter.CollectWorkflowJobEvent(&github.WorkflowJobEvent{Action: github.String("completed"), WorkflowJob: job(12, 1), Repo: repository})
+
+	// Then
+	assert.Equal(t, 3.0, testutil.ToFloat64(jobCounter), "every job completion is collected once")
+	assert.Equal(t, 2.0, testutil.ToFloat64(runCounter), "the unsettled run is not reconciled")
+}
+
+func Test_WorkflowReconciler_InvalidRepository(t *testing.T) {
+	reconciler := server.NewWorkflowReconciler(log.NewNopLogger(), server.Opts{ReconcileRepositories: []string{"some-repo"}}, nil, nil)
+
+	_, err := reconciler.Targets()
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Replayer feeds captured webhook deliveries through the webhook handler, either of an exporter
+// created in process or of a running exporter.
+type Replayer struct {
+	Logger log.Logger
+	Opts   Opts
+}
+
+func NewReplayer(logger log.Logger, opts Opts) *Replayer {
+	return &Replayer{
+		Logger: logger,
+		Opts:   opts,
+	}
+}
+
+// ReplayInProcess passes the deliveries, in order, to the webhook handler of an exporter whose
+// metrics are registered with a fresh registry, and writes its workflow metrics to w in the
+// Prometheus text format. The deliveries are signed with a random secret, so that the captured
+// ones don't need the webhook secret. It returns the number of deliveries that were rejected.
+func (r *Replayer) ReplayInProcess(ctx context.Context, w io.Writer, deliveries []Delivery) (int, error) {
+	secret, err := randomSecret()
+	if err != nil {
+		return 0, err
+	}
+
+	opts := r.Opts
+	opts.GitHubToken = secret
+	opts.AdditionalGitHubTokens = nil
+	opts.TargetGitHubTokens = nil
+	// A single worker processes the deliveries in order.
+	opts.EventWorkers = 1
+	opts.EventQueueSize = len(deliveries) + 1
+
+	reg := prometheus.NewRegistry()
+	exporter, err := newWorkflowMetricsExporter(r.Logger, opts, NewPrometheusObserver(reg, opts))
+	if err != nil {
+		return 0, err
+	}
+
+	var rejected int
+	for _, delivery := range deliveries {
+		req, err := delivery.request("/", secret)
+		if err != nil {
+			return rejected, err
+		}
+		res := httptest.NewRecorder()
+		exporter.HandleGHWebHook(res, req)
+		if !r.accepted(delivery, res.Code) {
+			rejected++
+		}
+	}
+	if err := exporter.Shutdown(ctx); err != nil {
+		return rejected, err
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		return rejected, err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return rejected, err
+		}
+	}
+	return rejected, nil
+}
+
+// ReplayTo signs the deliveries with the webhook secrets and posts them, in order, to the webhook
+// URL of a running exporter. It returns the number of deliveries that were rejected.
+func (r *Replayer) ReplayTo(ctx context.Context, client *http.Client, url string, deliveries []Delivery) (int, error) {
+	var rejected int
+	for _, delivery := range deliveries {
+		status, err := r.Send(ctx, client, url, delivery)
+		if err != nil {
+			return rejected, err
+		}
+		if !r.accepted(delivery, status) {
+			rejected++
+		}
+	}
+	return rejected, nil
+}
+
+// Send signs a delivery with the webhook secret the exporter verifies it with, picked by its
+// delivery target like the exporter does, posts it to the webhook URL of a running exporter and
+// returns the status of the response.
+func (r *Replayer) Send(ctx context.Context, client *http.Client, url string, delivery Delivery) (int, error) {
+	payload, err := delivery.Payload()
+	if err != nil {
+		return 0, fmt.Errorf("reading the payload of delivery %s: %w", delivery.Header("X-GitHub-Delivery"), err)
+	}
+	req, err := delivery.request(url, "")
+	if err != nil {
+		return 0, err
+	}
+	_, secrets, err := r.Opts.secretsForDelivery(req.Header, payload)
+	if err != nil {
+		return 0, fmt.Errorf("signing delivery %s: %w", delivery.Header("X-GitHub-Delivery"), err)
+	}
+	if len(secrets) == 0 {
+		return 0, fmt.Errorf("webhook secret not configured")
+	}
+	req.Header.Set("X-Hub-Signature-256", signWebhookPayload(secrets[0], payload))
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("posting delivery %s: %w", delivery.Header("X-GitHub-Delivery"), err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// accepted logs the response status of a delivery and reports whether it was accepted.
+func (r *Replayer) accepted(delivery Delivery, status int) bool {
+	keyvals := []interface{}{"eventType", delivery.Header("X-GitHub-Event"), "deliveryID", delivery.Header("X-GitHub-Delivery"), "status", status}
+	if status < 200 || status >= 300 {
+		_ = level.Warn(r.Logger).Log(append([]interface{}{"msg", "delivery rejected"}, keyvals...)...)
+		return false
+	}
+	_ = level.Debug(r.Logger).Log(append([]interface{}{"msg", "delivery replayed"}, keyvals...)...)
+	return true
+}
+
+func randomSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const replayJobCompleted = `{"action":"completed","workflow_job":{"id":2,"run_id":1,"status":"completed","conclusion":"success","name":"Test","workflow_name":"Build","head_branch":"main","started_at":"2024-03-01T10:00:00Z","completed_at":"2024-03-01T10:01:00Z"},"repository":{"name":"replay-repo","owner":{"login":"replay-org"}}}`
+
+func Test_ReadDeliveries(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	jsonl := `{"headers":{"X-GitHub-Event":"workflow_job","X-GitHub-Delivery":"first"},"body":` + replayJobCompleted + `}
+
+{"event":"ping","delivery_id":"second","body":` + strconv.Quote(`{"zen":"Keep it logically awesome."}`) + `}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.jsonl"), []byte(jsonl), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"event":"ping","body":{}}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte(`ignored`), 0o600))
+
+	// When
+	deliveries, err := server.ReadDeliveries(dir)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+	assert.Equal(t, "workflow_job", deliveries[0].Header("x-github-event"))
+	assert.Equal(t, "second", deliveries[1].Header("X-GitHub-Delivery"))
+	payload, err := deliveries[1].Payload()
+	require.NoError(t, err)
+	assert.Equal(t, `{"zen":"Keep it logically awesome."}`, string(payload))
+}
+
+func Test_Replayer_ReplayInProcess(t *testing.T) {
+	// Given
+	deliveries := []server.Delivery{
+		{ID: "first", Event: "workflow_job", Body: []byte(replayJobCompleted)},
+		{ID: "first", Event: "workflow_job", Body: []byte(replayJobCompleted)},
+		{ID: "second", Event: "push", Body: []byte(`{}`)},
+	}
+	replayer := server.NewReplayer(log.NewNopLogger(), server.Opts{DeduplicationWindow: time.Minute})
+	out := &bytes.Buffer{}
+
+	// When
+	rejected, err := replayer.ReplayInProcess(context.Background(), out, deliveries)
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 1, rejected, "push events are not implemented")
+	assert.Contains(t, out.String(), `workflow_job_status_count{branch="main",conclusion="success",job_name="Test",org="replay-org",repo="replay-repo",runner_group="",status="completed",workflow_name="Build"} 1`)
+	assert.Contains(t, out.String(), `duplicate_deliveries_total{event="workflow_job",reason="delivery_id"} 1`, "the delivery metrics are replayed too")
+	assert.Contains(t, out.String(), `webhook_queue_capacity 4`)
+}
+
+func Test_Replayer_ReplayTo(t *testing.T) {
+	// Given
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-GitHub-Delivery"))
+		exporter := &server.WorkflowMetricsExporter{Logger: log.NewNopLogger(), Opts: server.Opts{GitHubToken: "replay-secret"}}
+		if err := exporter.VerifyRequestSignature(r.Header, mustReadBody(t, r)); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(srv.Close)
+	deliveries := []server.Delivery{
+		{Headers: map[string]string{"X-GitHub-Event": "ping", "X-GitHub-Delivery": "first", "X-Hub-Signature-256": "sha256=stale"}, Body: []byte(`{}`)},
+		{ID: "second", Event: "workflow_job", Body: []byte(replayJobCompleted)},
+	}
+
+	// When
+	rejected, err := server.NewReplayer(log.NewNopLogger(), server.Opts{GitHubToken: "replay-secret"}).ReplayTo(context.Background(), srv.Client(), srv.URL, deliveries)
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 0, rejected)
+	assert.Equal(t, []string{"first", "second"}, received)
+}
+
+func Test_Replayer_Send_SignsWithTheSecretOfTheTarget(t *testing.T) {
+	// Given
+	opts := server.Opts{
+		GitHubToken: "global-secret",
+		TargetGitHubTokens: map[string][]string{
+			"hook:42":          {"hook-secret"},
+			"owner:replay-org": {"owner-secret"},
+		},
+		TargetOwners: map[string][]string{"hook:42": {"replay-org"}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exporter := &server.WorkflowMetricsExporter{Logger: log.NewNopLogger(), Opts: opts}
+		if err := exporter.VerifyRequestSignature(r.Header, mustReadBody(t, r)); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(srv.Close)
+	replayer := server.NewReplayer(log.NewNopLogger(), opts)
+
+	// When
+	hookStatus, hookErr := replayer.Send(context.Background(), srv.Client(), srv.URL, server.Delivery{ID: "hook", Event: "workflow_job", Headers: map[string]string{"X-GitHub-Hook-ID": "42"}, Body: []byte(replayJobCompleted)})
+	ownerStatus, ownerErr := replayer.Send(context.Background(), srv.Client(), srv.URL, server.Delivery{ID: "owner", Event: "workflow_job", Body: []byte(replayJobCompleted)})
+	_, unknownErr := replayer.Send(context.Background(), srv.Client(), srv.URL, server.Delivery{ID: "unknown", Event: "ping", Body: []byte(`{}`)})
+
+	// Then
+	require.NoError(t, hookErr)
+	assert.Equal(t, http.StatusAccepted, hookStatus)
+	require.NoError(t, ownerErr)
+	assert.Equal(t, http.StatusAccepted, ownerStatus)
+	assert.ErrorIs(t, unknownErr, server.ErrUnknownTarget)
+}
+
+func mustReadBody(t *testing.T, r *http.Request) []byte {
+	buf := &bytes.Buffer{}
+	_, err := buf.ReadFrom(r.Body)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Runner statuses exported by runners_by_labels and runners_by_group.
+const (
+	runnerStatusIdle    = "idle"
+	runnerStatusBusy    = "busy"
+	runnerStatusOffline = "offline"
+)
+
+// runnersPerPage is the maximum page size of the runner endpoints.
+const runnersPerPage = 100
+
+// RunnersExporter polls the self-hosted runners of the configured organizations, enterprises
+// and repositories.
+type RunnersExporter struct {
+	GHClients *GitHubClients
+	Logger    log.Logger
+	Opts      Opts
+}
+
+// runnersTarget is an organization, enterprise or repository whose runners are polled.
+type runnersTarget struct {
+	org, repo, enterprise string
+}
+
+// String returns the name of the target reported by the poller health metrics.
+func (t runnersTarget) String() string {
+	switch {
+	case t.enterprise != "":
+		return GitHubAccountEnterprise + ":" + t.enterprise
+	case t.repo != "":
+		return "repo:" + t.org + "/" + t.repo
+	default:
+		return GitHubAccountOrg + ":" + t.org
+	}
+}
+
+// groupedRunner is a runner and the name of its runner group, empty for repository runners.
+type groupedRunner struct {
+	group  string
+	runner *github.Runner
+}
+
+func NewRunnersExporter(logger log.Logger, opts Opts, clients *GitHubClients) *RunnersExporter {
+	return &RunnersExporter{
+		Logger:    logger,
+		Opts:      opts,
+		GHClients: clients,
+	}
+}
+
+// StartRunners polls the runners of every configured organization, enterprise and repository,
+// each one on its own.
+func (c *RunnersExporter) StartRunners(ctx context.Context) error {
+	if c.Opts.RunnersPollInterval <= 0 {
+		return errors.New("runners poll interval not configured")
+	}
+	if !c.GHClients.Enabled() {
+		return errors.New("github credentials not configured")
+	}
+
+	targets, err := c.targets()
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("github org, enterprise or repository not configured")
+	}
+
+	for _, target := range targets {
+		go func(target runnersTarget) {
+			poll(ctx, "runners", target.String(), c.Opts.RunnersPollInterval, func(ctx context.Context) error {
+				return c.collectRunners(ctx, target)
+			})
+			_ = level.Info(c.Logger).Log("msg", "stopped polling for runner metrics", "org", target.org, "repo", target.repo, "enterprise", target.enterprise)
+		}(target)
+	}
+
+	return nil
+}
+
+// targets returns the organizations and enterprises of the GitHub accounts and the repositories
+// whose runners are polled.
+func (c *RunnersExporter) targets() ([]runnersTarget, error) {
+	var targets []runnersTarget
+	for _, account := range c.Opts.GitHubAccounts {
+		switch account.Kind {
+		case GitHubAccountOrg:
+			targets = append(targets, runnersTarget{org: account.Login})
+		case GitHubAccountEnterprise:
+			targets = append(targets, runnersTarget{enterprise: account.Login})
+		}
+	}
+	for _, repository := range c.Opts.RunnerRepositories {
+		owner, repo, found := strings.Cut(repository, "/")
+		if !found || owner == "" || repo == "" {
+			return nil, fmt.Errorf("invalid runner repository %q, expected <owner>/<repo>", repository)
+		}
+		targets = append(targets, runnersTarget{org: owner, repo: repo})
+	}
+	return targets, nil
+}
+
+// collectRunners collects the status of the runners of a target.
+func (c *RunnersExporter) collectRunners(ctx context.Context, target runnersTarget) error {
+	runners, err := c.listRunners(ctx, target)
+	if err != nil {
+		_ = c.Logger.Log("msg", "failed to list the runners", "org", target.org, "repo", target.repo, "enterprise", target.enterprise, "err", err)
+		return err
+	}
+
+	org, enterprise := c.Opts.ownerLabel(target.org), c.Opts.ownerLabel(target.enterprise)
+	targetLabels := prometheus.Labels{"org": org, "repo": target.repo, "enterprise": enterprise}
+	runnerOnlineGauge.DeletePartialMatch(targetLabels)
+	runnerBusyGauge.DeletePartialMatch(targetLabels)
+	runnersByLabelsGauge.DeletePartialMatch(targetLabels)
+	runnersByGroupGauge.DeletePartialMatch(targetLabels)
+
+	for _, grouped := range runners {
+		runner := grouped.runner
+		labels := runnerLabelNames(runner)
+		status := runnerStatus(runner)
+
+		online, busy := 0.0, 0.0
+		if status != runnerStatusOffline {
+			online = 1
+		}
+		if status == runnerStatusBusy {
+			busy = 1
+		}
+		runnerOnlineGauge.WithLabelValues(org, target.repo, enterprise, grouped.group, runner.GetName(), runner.GetOS(), labels).Set(online)
+		runnerBusyGauge.WithLabelValues(org, target.repo, enterprise, grouped.group, runner.GetName(), runner.GetOS(), labels).Set(busy)
+		runnersByLabelsGauge.WithLabelValues(org, target.repo, enterprise, grouped.group, labels, status).Inc()
+		runnersByGroupGauge.WithLabelValues(org, target.repo, enterprise, grouped.group, status).Inc()
+	}
+
+	return nil
+}
+
+// listRunners returns the runners of a target. The runners of organizations and enterprises are
+// listed per runner group.
+func (c *RunnersExporter) listRunners(ctx context.Context, target runnersTarget) ([]groupedRunner, error) {
+	switch {
+	case target.enterprise != "":
+		client, err := c.GHClients.ForEnterprise(ctx, target.enterprise)
+		if err != nil {
+			return nil, err
+		}
+		return c.listEnterpriseRunners(ctx, client, target.enterprise)
+	case target.repo != "":
+		client, err := c.GHClients.ForRepository(ctx, target.org, target.repo)
+		if err != nil {
+			return nil, err
+		}
+		return listPages(func(opts github.ListOptions) ([]groupedRunner, *github.Response, error) {
+			runners, res, err := client.Actions.ListRunners(ctx, target.org, target.repo, &github.ListRunnersOptions{ListOptions: opts})
+			return groupRunners("", runners), res, err
+		})
+	default:
+		client, err := c.GHClients.ForOrg(ctx, target.org)
+		if err != nil {
+			return nil, err
+		}
+		return c.listOrgRunners(ctx, client, target.org)
+	}
+}
+
+func (c *RunnersExporter) listOrgRunners(ctx context.Context, client *github.Client, org string) ([]groupedRunner, error) {
+	groups, err := listPages(func(opts github.ListOptions) ([]*github.RunnerGroup, *github.Response, error) {
+		groups, res, err := client.Actions.ListOrganizationRunnerGroups(ctx, org, &github.ListOrgRunnerGroupOptions{ListOptions: opts})
+		if groups == nil {
+			return nil, res, err
+		}
+		return groups.RunnerGroups, res, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing runner groups: %w", err)
+	}
+
+	var result []groupedRunner
+	for _, group := range groups {
+		runners, err := listPages(func(opts github.ListOptions) ([]groupedRunner, *github.Response, error) {
+			runners, res, err := client.Actions.ListRunnerGroupRunners(ctx, org, group.GetID(), &opts)
+			return groupRunners(group.GetName(), runners), res, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing runners of runner group %s: %w", group.GetName(), err)
+		}
+		result = append(result, runners...)
+	}
+	return result, nil
+}
+
+func (c *RunnersExporter) listEnterpriseRunners(ctx context.Context, client *github.Client, enterprise string) ([]groupedRunner, error) {
+	groups, err := listPages(func(opts github.ListOptions) ([]*github.EnterpriseRunnerGroup, *github.Response, error) {
+		groups, res, err := client.Enterprise.ListRunnerGroups(ctx, enterprise, &github.ListEnterpriseRunnerGroupOptions{ListOptions: opts})
+		if groups == nil {
+			return nil, res, err
+		}
+		return groups.RunnerGroups, res, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing runner groups: %w", err)
+	}
+
+	var result []groupedRunner
+	for _, group := range groups {
+		runners, err := listPages(func(opts github.ListOptions) ([]groupedRunner, *github.Response, error) {
+			runners, res, err := client.Enterprise.ListRunnerGroupRunners(ctx, enterprise, group.GetID(), &opts)
+			return groupRunners(group.GetName(), runners), res, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing runners of runner group %s: %w", group.GetName(), err)
+		}
+		result = append(result, runners...)
+	}
+	return result, nil
+}
+
+// listPages returns the items of every page of a list endpoint.
+func listPages[T any](list func(opts github.ListOptions) ([]T, *github.Response, error)) ([]T, error) {
+	var result []T
+	opts := github.ListOptions{PerPage: runnersPerPage}
+	for {
+		items, res, err := list(opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+		if res == nil || res.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+func groupRunners(group string, runners *github.Runners) []groupedRunner {
+	if runners == nil {
+		return nil
+	}
+	result := make([]groupedRunner, 0, len(runners.Runners))
+	for _, runner := range runners.Runners {
+		result = append(result, groupedRunner{group: group, runner: runner})
+	}
+	return result
+}
+
+// runnerStatus returns whether a runner is idle, busy or offline.
+func runnerStatus(runner *github.Runner) string {
+	switch {
+	case runner.GetStatus() != "online":
+		return runnerStatusOffline
+	case runner.GetBusy():
+		return runnerStatusBusy
+	default:
+		return runnerStatusIdle
+	}
+}
+
+// runnerLabelNames returns the sorted, comma separated labels of a runner.
+func runnerLabelNames(runner *github.Runner) string {
+	labels := make([]string, 0, len(runner.Labels))
+	for _, label := range runner.Labels {
+		labels = append(labels, label.GetName())
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRunner(name, status string, busy bool, labels ...string) *github.Runner {
+	runner := &github.Runner{Name: github.String(name), OS: github.String("linux"), Status: github.String(status), Busy: github.Bool(busy)}
+	for _, label := range labels {
+		runner.Labels = append(runner.Labels, &github.RunnerLabels{Name: github.String(label)})
+	}
+	return runner
+}
+
+func Test_RunnersExporter_CollectRunners(t *testing.T) {
+	// Given
+	opts := testGitHubAPI(t, map[string]http.HandlerFunc{
+		"/orgs/runners-org/actions/runner-groups": respondJSON(github.RunnerGroups{RunnerGroups: []*github.RunnerGroup{
+			{ID: github.Int64(1), Name: github.String("Default")},
+			{ID: github.Int64(2), Name: github.String("gpu")},
+		}}),
+		"/orgs/runners-org/actions/runner-groups/1/runners": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") != "2" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, "http://"+r.Host, r.URL.Path))
+				_ = json.NewEncoder(w).Encode(github.Runners{Runners: []*github.Runner{
+					testRunner("runner-1", "online", true, "self-hosted", "linux"),
+				}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(github.Runners{Runners: []*github.Runner{
+				testRunner("runner-2", "online", false, "linux", "self-hosted"),
+				testRunner("runner-3", "offline", false, "linux", "self-hosted"),
+			}})
+		},
+		"/orgs/runners-org/actions/runner-groups/2/runners": respondJSON(github.Runners{Runners: []*github.Runner{
+			testRunner("gpu-1", "online", true, "self-hosted", "gpu"),
+		}}),
+		"/repos/runners-org/some-repo/actions/runners": respondJSON(github.Runners{Runners: []*github.Runner{
+			testRunner("repo-runner", "online", false, "self-hosted"),
+		}}),
+	})
+	opts.GitHubAccounts = []server.GitHubAccount{{Kind: server.GitHubAccountOrg, Login: "runners-org"}}
+	opts.RunnerRepositories = []string{"runners-org/some-repo"}
+	clients, err := server.NewGitHubClients(log.NewNopLogger(), opts)
+	require.NoError(t, err)
+	exporter := server.NewRunnersExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), opts, clients)
+	targets, err := exporter.Targets()
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	org := opts.OwnerLabel("runners-org")
+	server.RunnerOnlineGauge.WithLabelValues(org, "", "", "Default", "removed-runner", "linux", "linux").Set(1)
+
+	// When
+	for _, target := range targets {
+		exporter.CollectRunners(context.Background(), target)
+	}
+
+	// Then
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnerOnlineGauge.WithLabelValues(org, "", "", "Default", "runner-1", "linux", "linux,self-hosted")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnerBusyGauge.WithLabelValues(org, "", "", "Default", "runner-1", "linux", "linux,self-hosted")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(server.RunnerOnlineGauge.WithLabelValues(org, "", "", "Default", "runner-3", "linux", "linux,self-hosted")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnerOnlineGauge.WithLabelValues(org, "some-repo", "", "", "repo-runner", "linux", "self-hosted")))
+	assert.Equal(t, 5, testutil.CollectAndCount(server.RunnerOnlineGauge), "removed runners are dropped")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnersByLabelsGauge.WithLabelValues(org, "", "", "Default", "linux,self-hosted", server.RunnerStatusBusy)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnersByLabelsGauge.WithLabelValues(org, "", "", "Default", "linux,self-hosted", server.RunnerStatusIdle)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnersByLabelsGauge.WithLabelValues(org, "", "", "Default", "linux,self-hosted", server.RunnerStatusOffline)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnersByGroupGauge.WithLabelValues(org, "", "", "gpu", server.RunnerStatusBusy)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.RunnersByGroupGauge.WithLabelValues(org, "", "", "Default", server.RunnerStatusIdle)))
+}
+
+func Test_RunnersExporter_InvalidRepository(t *testing.T) {
+	exporter := server.NewRunnersExporter(log.NewNopLogger(), server.Opts{RunnerRepositories: []string{"some-repo"}}, nil)
+
+	_, err := exporter.Targets()
+	assert.Error(t, err)
+}
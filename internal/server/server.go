@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -22,11 +25,226 @@ type Opts struct {
 	WebhookPath          string
 	// GitHub webhook token.
 	GitHubToken string
+	// Additional webhook tokens that are accepted as well, e.g. while rotating GitHubToken.
+	AdditionalGitHubTokens []string
+	// Webhook tokens per delivery target, keyed by WebhookTargetKey.
+	TargetGitHubTokens map[string][]string
+	// Lowercase logins of the owners each hook and installation target of TargetGitHubTokens is
+	// bound to, keyed by WebhookTargetKey. A delivery verified with the secret of such a target but
+	// about another owner is rejected.
+	TargetOwners map[string][]string
+	// Verify the deliveries that match no target of TargetGitHubTokens with the global tokens
+	// instead of rejecting them.
+	TargetFallbackGlobalTokens bool
+	// Accept the legacy SHA-1 X-Hub-Signature header when no SHA-256 signature is sent.
+	AllowSHA1Signature bool
+	// Time window in which deliveries with an already seen X-GitHub-Delivery GUID are dropped.
+	// Zero disables deduplication.
+	DeduplicationWindow time.Duration
+	// Maximum number of keys remembered for deduplication, zero means unbounded.
+	DeduplicationMaxEntries int
+	// Also drop workflow_job events repeating an already seen (run_id, job_id, action) tuple.
+	DeduplicateJobActions bool
+	// Time after which a queued or running job without any update stops being counted as in flight.
+	// Zero disables the tracking of in flight jobs.
+	InFlightJobTTL time.Duration
+	// Label the workflow job metrics with the sorted labels the job requested from runners.
+	WorkflowJobRunnerLabels bool
+	// Label the workflow job metrics with the name of the runner that ran the job.
+	WorkflowJobRunnerName bool
+	// Names of the workflow job steps exported by the step metrics.
+	StepNames []string
+	// Regular expression matching the names of the workflow job steps exported by the step metrics.
+	// When neither StepNames nor StepNameRegex are set, every step is exported.
+	StepNameRegex *regexp.Regexp
+	// Number of workers processing webhook events. Zero processes every event in its own goroutine.
+	EventWorkers int
+	// Number of webhook events that can wait for a worker before deliveries are rejected.
+	EventQueueSize int
 	// GitHub API token.
-	GitHubAPIToken        string
-	GitHubOrg             string
-	GitHubUser            string
+	GitHubAPIToken string
+	// ID of the GitHub App authenticating the GitHub API calls instead of GitHubAPIToken.
+	GitHubAppID int64
+	// PEM encoded private key of the GitHub App.
+	GitHubAppPrivateKey []byte
+	// ID of the GitHub App installation. When zero, the installation of each organization or
+	// user is looked up.
+	GitHubAppInstallationID int64
+	// GitHub Enterprise Server API URL, e.g. https://github.example.com/api/v3/. Empty for github.com.
+	GitHubAPIURL string
+	// GitHub Enterprise Server upload URL, derived from GitHubAPIURL when empty.
+	GitHubUploadURL string
+	// PEM encoded CA certificates trusted by the GitHub API clients besides the system ones.
+	GitHubCACertificates []byte
+	// Proxy used by the GitHub API clients instead of the one from the environment.
+	GitHubProxyURL string
+	// Personal access token used for the enterprise accounts, GitHubAPIToken when empty.
+	GitHubEnterpriseToken string
+	// Remaining rate limit of a token below which its GitHub API requests are held back until the
+	// rate limit is reset.
+	GitHubAPIRateLimitThreshold int
+	// Organizations and users polled from the GitHub API.
+	GitHubAccounts        []GitHubAccount
 	BillingAPIPollSeconds int
+	// Collect the usage per repository and SKU from the usage report of the enhanced billing
+	// platform, falling back to the actions billing endpoint for accounts without usage report.
+	BillingUsageReport bool
+	// Time between two polls of the self-hosted runners, zero disables them.
+	RunnersPollInterval time.Duration
+	// Repositories, as <owner>/<repo>, whose self-hosted runners are polled besides the ones of
+	// the organizations and enterprises of GitHubAccounts.
+	RunnerRepositories []string
+	// Time between two polls of the actions cache usage of the organizations, zero disables them.
+	CachePollInterval time.Duration
+	// Number of the largest cache keys of each repository to export, zero disables them.
+	CacheLargestKeys int
+	// Time between two reconciliations of the workflow runs and jobs of ReconcileRepositories
+	// with the GitHub API, zero disables them.
+	ReconcileInterval time.Duration
+	// Repositories, as <owner>/<repo>, whose workflow runs and jobs are reconciled.
+	ReconcileRepositories []string
+	// How far back the reconciler looks for completed workflow runs, by their creation time.
+	ReconcileLookback time.Duration
+	// Directory of the journal of the webhook deliveries, empty disables it.
+	JournalDir string
+	// Size at which a journal file is rotated, zero never rotates them.
+	JournalMaxFileSize int64
+	// Maximum number of journal files kept, zero keeps them all.
+	JournalMaxFiles int
+	// Time for which the rotated journal files are kept, zero keeps them forever.
+	JournalRetention time.Duration
+	// Record the rejected deliveries in the journal too, along with the headers of every delivery.
+	JournalCapture bool
+	// Store the state of the exporter is persisted to across restarts, empty disables it.
+	StateStore string
+	// Path of the state file of the file store.
+	StatePath string
+	// Interval at which the state is saved, besides on shutdown. Zero saves it on shutdown only.
+	StateSaveInterval time.Duration
+	// Persist the values of the workflow counters along with the state.
+	StateCounters bool
+	// Backend the replicas share their deduplication and job lifecycle state through, empty
+	// disables the HA mode.
+	HABackend string
+	// URL of the server of the redis backend.
+	HARedisURL string
+	// Prefix of the keys of the shared state.
+	HAKeyPrefix string
+	// Elect a leader among the replicas, the only one polling the GitHub API.
+	HALeaderElection bool
+	// Duration of the lease of the leader.
+	HALeaseDuration time.Duration
+	// Identity of the replica in the leader election, the hostname when empty.
+	HAIdentity string
+}
+
+// Kinds of GitHub accounts polled from the GitHub API.
+const (
+	GitHubAccountOrg        = "org"
+	GitHubAccountUser       = "user"
+	GitHubAccountEnterprise = "enterprise"
+)
+
+// GitHubAccount is an organization or a user polled from the GitHub API.
+type GitHubAccount struct {
+	// Kind is GitHubAccountOrg, GitHubAccountUser or GitHubAccountEnterprise.
+	Kind  string
+	Login string
+	// Time between two polls of the account, Opts.BillingAPIPollSeconds when zero.
+	PollInterval time.Duration
+}
+
+// Kinds of delivery targets webhook tokens can be configured for.
+const (
+	WebhookTargetHook               = "hook"
+	WebhookTargetInstallationTarget = "installation-target"
+	WebhookTargetOwner              = "owner"
+
+	defaultWebhookTarget = "default"
+)
+
+// WebhookTargetKey returns the Opts.TargetGitHubTokens key for the given kind of target and its
+// value, which is a hook ID, an installation target ID or a repository owner login.
+func WebhookTargetKey(kind, value string) (string, error) {
+	switch kind {
+	case WebhookTargetHook, WebhookTargetInstallationTarget, WebhookTargetOwner:
+	default:
+		return "", fmt.Errorf("unknown webhook target %q, must be one of %s, %s or %s", kind, WebhookTargetHook, WebhookTargetInstallationTarget, WebhookTargetOwner)
+	}
+	if value == "" {
+		return "", fmt.Errorf("missing value for webhook target %q", kind)
+	}
+
+	return webhookTargetKey(kind, value), nil
+}
+
+func webhookTargetKey(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	return kind + ":" + strings.ToLower(value)
+}
+
+// stepSelected reports whether the metrics of the workflow job step with the given name are exported.
+func (o Opts) stepSelected(name string) bool {
+	if len(o.StepNames) == 0 && o.StepNameRegex == nil {
+		return true
+	}
+	for _, stepName := range o.StepNames {
+		if stepName == name {
+			return true
+		}
+	}
+	return o.StepNameRegex != nil && o.StepNameRegex.MatchString(name)
+}
+
+// ownerLabel returns the org or user label value of an owner polled from the GitHub API. With
+// GitHub Enterprise Server it is prefixed with the host, so that several instances can be told apart.
+func (o Opts) ownerLabel(owner string) string {
+	if o.GitHubAPIURL == "" || owner == "" {
+		return owner
+	}
+	apiURL, err := url.Parse(o.GitHubAPIURL)
+	if err != nil || apiURL.Host == "" {
+		return owner
+	}
+	return apiURL.Host + "/" + owner
+}
+
+// accountLabels returns the org, user and enterprise label values of an account polled from
+// the GitHub API.
+func (o Opts) accountLabels(account GitHubAccount) (org, user, enterprise string) {
+	switch account.Kind {
+	case GitHubAccountUser:
+		return "", o.ownerLabel(account.Login), ""
+	case GitHubAccountEnterprise:
+		return "", "", o.ownerLabel(account.Login)
+	default:
+		return o.ownerLabel(account.Login), "", ""
+	}
+}
+
+// haIdentity returns the identity of the replica in the leader election.
+func (o Opts) haIdentity() string {
+	if o.HAIdentity != "" {
+		return o.HAIdentity
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return fmt.Sprintf("github-actions-exporter-%d", os.Getpid())
+}
+
+// webhookSecrets returns every accepted webhook secret. The index of a secret in the
+// returned list is the one reported by the webhook_secret_validations_total metric.
+func (o Opts) webhookSecrets() []string {
+	secrets := make([]string, 0, 1+len(o.AdditionalGitHubTokens))
+	for _, secret := range append([]string{o.GitHubToken}, o.AdditionalGitHubTokens...) {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
 }
 
 type Server struct {
@@ -35,24 +253,62 @@ type Server struct {
 	serverIngress           *http.Server
 	workflowMetricsExporter *WorkflowMetricsExporter
 	billingExporter         *BillingMetricsExporter
+	elector                 *leaderElector
 	opts                    Opts
 }
 
-func NewServer(logger log.Logger, opts Opts) *Server {
+func NewServer(logger log.Logger, opts Opts) (*Server, error) {
+	githubClients, err := NewGitHubClients(logger, opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating GitHub API clients: %w", err)
+	}
+
 	muxMetrics := http.NewServeMux()
 	httpServerMetrics := &http.Server{
 		Handler:           muxMetrics,
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	billingExporter := NewBillingMetricsExporter(logger, opts)
-	err := billingExporter.StartOrgBilling(context.TODO())
+	workflowExporter, err := NewWorkflowMetricsExporter(logger, opts)
 	if err != nil {
-		_ = level.Info(logger).Log("msg", fmt.Sprintf("not exporting org billing: %v", err))
+		return nil, err
 	}
-	err = billingExporter.StartUserBilling(context.TODO())
+
+	// In HA mode, the replicas poll the GitHub API only while they lead.
+	ctx := context.TODO()
+	var elector *leaderElector
+	if workflowExporter.shared != nil {
+		pingCtx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+		if err := workflowExporter.shared.Ping(pingCtx); err != nil {
+			_ = level.Warn(logger).Log("msg", "HA backend unreachable", "err", err)
+		}
+		cancel()
+		if opts.HALeaderElection {
+			if opts.HALeaseDuration <= 0 {
+				return nil, errors.New("HA lease duration must be positive")
+			}
+			elector = newLeaderElector(logger, workflowExporter.shared, opts.haIdentity(), opts.HALeaseDuration)
+			elector.Start()
+			ctx = withLeaderElector(ctx, elector)
+		}
+	}
+
+	billingExporter := NewBillingMetricsExporter(logger, opts, githubClients)
+	err = billingExporter.StartBilling(ctx)
 	if err != nil {
-		_ = level.Info(logger).Log("msg", fmt.Sprintf("not exporting user billing: %v", err))
+		_ = level.Info(logger).Log("msg", fmt.Sprintf("not exporting billing: %v", err))
+	}
+
+	runnersExporter := NewRunnersExporter(logger, opts, githubClients)
+	err = runnersExporter.StartRunners(ctx)
+	if err != nil {
+		_ = level.Info(logger).Log("msg", fmt.Sprintf("not exporting runners: %v", err))
+	}
+
+	cacheExporter := NewCacheMetricsExporter(logger, opts, githubClients)
+	err = cacheExporter.StartCache(ctx)
+	if err != nil {
+		_ = level.Info(logger).Log("msg", fmt.Sprintf("not exporting cache usage: %v", err))
 	}
 
 	muxIngress := http.NewServeMux()
@@ -61,13 +317,19 @@ func NewServer(logger log.Logger, opts Opts) *Server {
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	workflowExporter := NewWorkflowMetricsExporter(logger, opts)
+	reconciler := NewWorkflowReconciler(logger, opts, githubClients, workflowExporter)
+	err = reconciler.StartReconciler(ctx)
+	if err != nil {
+		_ = level.Info(logger).Log("msg", fmt.Sprintf("not reconciling workflow runs: %v", err))
+	}
+
 	server := &Server{
 		logger:                  logger,
 		serverMetrics:           httpServerMetrics,
 		serverIngress:           httpServerIngress,
 		workflowMetricsExporter: workflowExporter,
 		billingExporter:         billingExporter,
+		elector:                 elector,
 		opts:                    opts,
 	}
 
@@ -76,7 +338,7 @@ func NewServer(logger log.Logger, opts Opts) *Server {
 	muxIngress.HandleFunc("/", server.handleRoot)
 	muxIngress.HandleFunc(opts.WebhookPath, workflowExporter.HandleGHWebHook)
 
-	return server
+	return server, nil
 }
 
 func (s *Server) Serve(_ context.Context) error {
@@ -105,12 +367,24 @@ func (s *Server) Serve(_ context.Context) error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	err := s.serverMetrics.Shutdown(ctx)
+	err := s.serverIngress.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Hand the polling over to another replica.
+	if s.elector != nil {
+		s.elector.Stop()
+	}
+
+	// Stop receiving webhooks before draining the queued events, and keep exposing metrics
+	// until the events are processed.
+	err = s.workflowMetricsExporter.Shutdown(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = s.serverIngress.Shutdown(ctx)
+	err = s.serverMetrics.Shutdown(ctx)
 	if err != nil {
 		return err
 	}
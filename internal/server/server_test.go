@@ -17,13 +17,14 @@ import (
 
 func Test_Server_MetricsRouteWithNoMetrics(t *testing.T) {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	srv := server.NewServer(logger, server.Opts{
+	srv, err := server.NewServer(logger, server.Opts{
 		MetricsPath:          "/metrics",
 		ListenAddressMetrics: ":8000",
 		ListenAddressIngress: ":8001",
 		WebhookPath:          "/webhook",
 		GitHubToken:          "webhook_token",
 	})
+	require.NoError(t, err)
 
 	t.Cleanup(func() {
 		err := srv.Shutdown(context.Background())
@@ -66,13 +67,14 @@ func Test_Server_MetricsRouteWithNoMetrics(t *testing.T) {
 
 func Test_Server_MetricsRouteAfterWorkflowJob(t *testing.T) {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	srv := server.NewServer(logger, server.Opts{
+	srv, err := server.NewServer(logger, server.Opts{
 		MetricsPath:          "/metrics",
 		ListenAddressMetrics: ":8000",
 		ListenAddressIngress: ":8001",
 		WebhookPath:          "/webhook",
 		GitHubToken:          webhookSecret,
 	})
+	require.NoError(t, err)
 
 	t.Cleanup(func() {
 		err := srv.Shutdown(context.Background())
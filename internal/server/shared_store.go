@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Backends the replicas of the exporter can share their state through.
+const (
+	HABackendRedis = "redis"
+)
+
+// sharedStoreTimeout bounds every call to the shared store, so that a slow backend doesn't hold
+// the webhook deliveries.
+const sharedStoreTimeout = 2 * time.Second
+
+// sharedStore is the state shared by the replicas of the exporter in HA mode: the keys used to
+// drop duplicate deliveries and events, and the leases used to elect a leader.
+type sharedStore interface {
+	// Add adds the key for ttl and reports whether it was not already present.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Contains reports whether the key is present.
+	Contains(ctx context.Context, key string) (bool, error)
+	// Remove removes the key.
+	Remove(ctx context.Context, key string) error
+	// AcquireLease acquires, or renews, the lease name for holder for ttl and reports whether
+	// holder holds it.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease releases the lease name if holder holds it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+	// Ping checks that the backend can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+func newSharedStore(opts Opts) (sharedStore, error) {
+	switch opts.HABackend {
+	case HABackendRedis:
+		store, err := newRedisSharedStore(opts)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported HA backend %q", opts.HABackend)
+	}
+}
+
+// shareState shares the deduplication keys and the job lifecycle with the other replicas through
+// store, so that each delivery and event is collected by a single replica.
+func (c *WorkflowMetricsExporter) shareState(store sharedStore) {
+	c.shared = store
+	if c.deliveries != nil {
+		c.deliveries.share(store, "deliveries/")
+	}
+	if c.jobActions != nil {
+		c.jobActions.share(store, "job_actions/")
+	}
+	c.queueTimeObserved().share(store, "queue_times/")
+	if c.completions != nil {
+		c.completions.share(store, "completions/")
+	}
+	if c.jobs != nil {
+		c.jobs.share(store)
+	}
+}
+
+// redisSharedStore shares the state through any server speaking the Redis protocol, e.g. Redis
+// or Valkey. Every key is prefixed, so that several exporters can share a server.
+type redisSharedStore struct {
+	client *redis.Client
+	prefix string
+}
+
+var (
+	// acquireLeaseScript sets the lease if it is free and renews it if it is held by the holder.
+	acquireLeaseScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder == false then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+	// releaseLeaseScript deletes the lease if it is held by the holder.
+	releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+func newRedisSharedStore(opts Opts) (*redisSharedStore, error) {
+	if opts.HARedisURL == "" {
+		return nil, errors.New("redis URL not configured")
+	}
+	redisOpts, err := redis.ParseURL(opts.HARedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the redis URL: %w", err)
+	}
+	return &redisSharedStore{
+		client: redis.NewClient(redisOpts),
+		prefix: opts.HAKeyPrefix,
+	}, nil
+}
+
+func (s *redisSharedStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, 1, ttl).Result()
+}
+
+func (s *redisSharedStore) Contains(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.prefix+key).Result()
+	return n > 0, err
+}
+
+func (s *redisSharedStore) Remove(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+func (s *redisSharedStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := acquireLeaseScript.Run(ctx, s.client, []string{s.prefix + "leases/" + name}, holder, ttl.Milliseconds()).Int()
+	return acquired == 1, err
+}
+
+func (s *redisSharedStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	return releaseLeaseScript.Run(ctx, s.client, []string{s.prefix + "leases/" + name}, holder).Err()
+}
+
+func (s *redisSharedStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *redisSharedStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,231 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) (*miniredis.Miniredis, server.Opts) {
+	redis := miniredis.RunT(t)
+	return redis, server.Opts{HABackend: server.HABackendRedis, HARedisURL: "redis://" + redis.Addr() + "/0", HAKeyPrefix: "test:"}
+}
+
+func Test_redisSharedStore_Keys(t *testing.T) {
+	// Given
+	redis, opts := newTestRedisStore(t)
+	store, err := server.NewSharedStore(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+
+	// When
+	added, err := store.Add(ctx, "some-key", time.Minute)
+	require.NoError(t, err)
+	addedAgain, err := store.Add(ctx, "some-key", time.Minute)
+	require.NoError(t, err)
+
+	// Then
+	assert.True(t, added)
+	assert.False(t, addedAgain)
+	assert.True(t, redis.Exists("test:some-key"))
+	redis.FastForward(time.Minute)
+	contains, err := store.Contains(ctx, "some-key")
+	require.NoError(t, err)
+	assert.False(t, contains, "the key expires")
+
+	_, err = store.Add(ctx, "other-key", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, store.Remove(ctx, "other-key"))
+	assert.False(t, redis.Exists("test:other-key"))
+}
+
+func Test_NewServer_FailsWhenTheHABackendCannotBeCreated(t *testing.T) {
+	opts := server.Opts{GitHubToken: "ha-secret", HABackend: server.HABackendRedis, HARedisURL: "http://localhost:6379"}
+
+	_, err := server.NewServer(log.NewNopLogger(), opts)
+
+	assert.ErrorContains(t, err, "HA backend")
+}
+
+func Test_WorkflowMetricsExporter_DropsDeliveriesOfOtherReplicas(t *testing.T) {
+	// Given
+	_, opts := newTestRedisStore(t)
+	opts.GitHubToken = "ha-secret"
+	opts.DeduplicationWindow = time.Hour
+	replicas := make([]*server.WorkflowMetricsExporter, 2)
+	for i := range replicas {
+		replica, err := server.NewWorkflowMetricsExporterWithObserver(log.NewNopLogger(), opts, server.NewPrometheusObserver(prometheus.NewRegistry(), opts))
+		require.NoError(t, err)
+		replicas[i] = replica
+		require.NotNil(t, replicas[i].Shared())
+	}
+	delivery := server.Delivery{ID: "first", Event: "ping", Body: []byte(`{}`)}
+
+	// When
+	var results []string
+	for _, replica := range replicas {
+		req, err := delivery.Request("/", "ha-secret")
+		require.NoError(t, err)
+		res := &server.JournalResponseWriter{ResponseWriter: httptest.NewRecorder()}
+		replica.HandleGHWebHook(res, req)
+		results = append(results, server.DeliveryResult(res.Status())+"/"+res.Result())
+		require.NoError(t, replica.Shutdown(context.Background()))
+	}
+
+	// Then
+	assert.Equal(t, []string{server.DeliveryResultAccepted + "/", server.DeliveryResultAccepted + "/" + server.DeliveryResultDuplicate}, results)
+}
+
+func Test_JobTracker_SyncsJobsProgressedOnOtherReplicas(t *testing.T) {
+	// Given
+	org := "ha-org"
+	_, opts := newTestRedisStore(t)
+	store, err := server.NewSharedStore(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	first, second := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics()), server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+	first.Share(store)
+	second.Share(store)
+	first.Observe(testJobEvent(org, "queued", 1, ""))
+	first.Observe(testJobEvent(org, "queued", 2, ""))
+	first.Observe(testJobEvent(org, "queued", 3, ""))
+
+	// When
+	second.Observe(testJobEvent(org, "in_progress", 1, ""))
+	second.Observe(testJobEvent(org, "completed", 2, ""))
+	first.Sync()
+
+	// Then
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""), "only the job that did not progress is still queued")
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateInProgress, org, ""))
+	second.Observe(testJobEvent(org, "queued", 1, ""))
+	assert.Equal(t, 1.0, inFlightJobs(server.JobStateQueued, org, ""), "a late queued event is not counted by the replica that saw the job start")
+}
+
+func Test_leaderElector_ElectsASingleLeader(t *testing.T) {
+	// Given
+	redis, opts := newTestRedisStore(t)
+	store, err := server.NewSharedStore(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	first := server.NewLeaderElector(log.NewNopLogger(), store, "first", 15*time.Second)
+	second := server.NewLeaderElector(log.NewNopLogger(), store, "second", 15*time.Second)
+
+	// When
+	first.Campaign()
+	second.Campaign()
+
+	// Then
+	assert.True(t, first.IsLeader())
+	assert.False(t, second.IsLeader())
+	assert.False(t, server.IsLeader(server.WithLeaderElector(context.Background(), second)))
+	assert.True(t, server.IsLeader(context.Background()), "every replica polls without leader election")
+
+	// When the leader is gone
+	redis.FastForward(15 * time.Second)
+	second.Campaign()
+	first.Campaign()
+
+	// Then another replica takes over
+	assert.True(t, second.IsLeader())
+	assert.False(t, first.IsLeader())
+
+	// When the leader stops
+	second.Start()
+	second.Stop()
+	first.Campaign()
+
+	// Then it hands the lease over
+	assert.True(t, first.IsLeader())
+}
+
+func Test_leaderElector_StepsDownWhenTheLeaseCannotBeRenewed(t *testing.T) {
+	// Given
+	redis, opts := newTestRedisStore(t)
+	store, err := server.NewSharedStore(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	now := time.Unix(1650308740, 0)
+	elector := server.NewLeaderElector(log.NewNopLogger(), store, "first", 15*time.Second)
+	elector.SetNow(func() time.Time { return now })
+	elector.Campaign()
+	require.True(t, elector.IsLeader())
+	server.RunnerOnlineGauge.WithLabelValues("leader-org", "", "", "", "runner-1", "linux", "self-hosted").Set(1)
+	server.CacheOrgSizeGauge.WithLabelValues("leader-org").Set(1000)
+	server.PollerLastSuccessGauge.WithLabelValues("runners", "org:leader-org").SetToCurrentTime()
+
+	// When
+	redis.SetError("server unavailable")
+	now = now.Add(5 * time.Second)
+	elector.Campaign()
+	stillLeading := elector.IsLeader()
+	now = now.Add(5 * time.Second)
+	elector.Campaign()
+
+	// Then
+	assert.True(t, stillLeading, "the lease is still held")
+	assert.False(t, elector.IsLeader(), "the replica steps down before the lease expires")
+	for _, gauge := range []*prometheus.GaugeVec{server.RunnerOnlineGauge, server.CacheOrgSizeGauge, server.PollerLastSuccessGauge} {
+		assert.Zero(t, testutil.CollectAndCount(gauge), "the replica stops exporting the polled gauges")
+	}
+}
+
+// blockingSharedStore is a shared store whose key calls block until released.
+type blockingSharedStore struct {
+	server.SharedStore
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSharedStore) Add(context.Context, string, time.Duration) (bool, error) {
+	s.called <- struct{}{}
+	<-s.release
+	return true, nil
+}
+
+func (s *blockingSharedStore) Contains(context.Context, string) (bool, error) {
+	s.called <- struct{}{}
+	<-s.release
+	return false, nil
+}
+
+func Test_JobTracker_DoesNotHoldTheLockWhileQueryingTheSharedStore(t *testing.T) {
+	// Given
+	store := &blockingSharedStore{called: make(chan struct{}), release: make(chan struct{})}
+	tracker := server.NewJobTracker(time.Hour, server.DefaultDeliveryMetrics())
+	tracker.Share(store)
+	observed := make(chan struct{})
+	go func() {
+		defer close(observed)
+		tracker.Observe(testJobEvent("blocking-org", "in_progress", 1, ""))
+	}()
+	<-store.called
+
+	// When
+	expired := make(chan struct{})
+	go func() {
+		defer close(expired)
+		tracker.Expire()
+	}()
+
+	// Then
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("Expire waited for the shared store")
+	}
+	close(store.release)
+	// The completed jobs are queried next.
+	<-store.called
+	<-observed
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Stores the state of the exporter can be persisted to.
+const (
+	StateStoreFile = "file"
+)
+
+// exporterState is the state of the workflow exporter persisted across restarts: the keys used to
+// drop duplicate deliveries and events, the tracked jobs and, optionally, the counter values.
+type exporterState struct {
+	SavedAt       time.Time         `json:"saved_at"`
+	Deliveries    []expiringKey     `json:"deliveries,omitempty"`
+	JobActions    []expiringKey     `json:"job_actions,omitempty"`
+	QueueTimes    []expiringKey     `json:"queue_times,omitempty"`
+	Completions   []expiringKey     `json:"completions,omitempty"`
+	Jobs          []trackedJobState `json:"jobs,omitempty"`
+	StartedJobs   []expiringKey     `json:"started_jobs,omitempty"`
+	CompletedJobs []expiringKey     `json:"completed_jobs,omitempty"`
+	Counters      []counterSample   `json:"counters,omitempty"`
+}
+
+// stateStore persists the state of the exporter.
+type stateStore interface {
+	// Load returns the saved state, or nil when no state was saved yet.
+	Load() (*exporterState, error)
+	Save(state *exporterState) error
+}
+
+func newStateStore(opts Opts) (stateStore, error) {
+	switch opts.StateStore {
+	case StateStoreFile:
+		if opts.StatePath == "" {
+			return nil, errors.New("state file path not configured")
+		}
+		return &fileStateStore{path: opts.StatePath}, nil
+	default:
+		return nil, fmt.Errorf("unsupported state store %q", opts.StateStore)
+	}
+}
+
+// fileStateStore saves the state as a JSON file, replaced atomically on every save.
+type fileStateStore struct {
+	path string
+}
+
+func (s *fileStateStore) Load() (*exporterState, error) {
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &exporterState{}
+	if err := json.Unmarshal(buf, state); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+	return state, nil
+}
+
+func (s *fileStateStore) Save(state *exporterState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o750); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+// restoreState restores the state saved by a previous run of the exporter.
+func (c *WorkflowMetricsExporter) restoreState() error {
+	state, err := c.state.Load()
+	if err != nil || state == nil {
+		return err
+	}
+
+	if c.deliveries != nil {
+		c.deliveries.restore(state.Deliveries)
+	}
+	if c.jobActions != nil {
+		c.jobActions.restore(state.JobActions)
+	}
+	c.queueTimeObserved().restore(state.QueueTimes)
+	if c.completions != nil {
+		c.completions.restore(state.Completions)
+	}
+	if c.jobs != nil {
+		c.jobs.restore(state.Jobs, state.StartedJobs, state.CompletedJobs)
+	}
+	var skipped int
+	if observer, ok := c.PrometheusObserver.(*PrometheusObserver); ok && c.Opts.StateCounters {
+		skipped = observer.restoreCounters(state.Counters)
+	}
+
+	_ = level.Info(c.Logger).Log("msg", "restored the state", "savedAt", state.SavedAt, "deliveries", len(state.Deliveries),
+		"jobs", len(state.Jobs), "counters", len(state.Counters), "skippedCounters", skipped)
+	return nil
+}
+
+// saveState saves the current state of the exporter.
+func (c *WorkflowMetricsExporter) saveState() error {
+	state := &exporterState{SavedAt: time.Now().UTC()}
+	if c.deliveries != nil {
+		state.Deliveries = c.deliveries.snapshot()
+	}
+	if c.jobActions != nil {
+		state.JobActions = c.jobActions.snapshot()
+	}
+	state.QueueTimes = c.queueTimeObserved().snapshot()
+	if c.completions != nil {
+		state.Completions = c.completions.snapshot()
+	}
+	if c.jobs != nil {
+		state.Jobs, state.StartedJobs, state.CompletedJobs = c.jobs.snapshot()
+	}
+	if observer, ok := c.PrometheusObserver.(*PrometheusObserver); ok && c.Opts.StateCounters {
+		counters, err := observer.snapshotCounters()
+		if err != nil {
+			return err
+		}
+		state.Counters = counters
+	}
+
+	if err := c.state.Save(state); err != nil {
+		stateSaveErrorsCounter.Inc()
+		return err
+	}
+	stateLastSaveGauge.SetToCurrentTime()
+	return nil
+}
+
+// startSavingState saves the state periodically until stopSavingState is called.
+func (c *WorkflowMetricsExporter) startSavingState(interval time.Duration) {
+	c.stateStop = make(chan struct{})
+	c.stateDone = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(c.stateDone)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.saveState(); err != nil {
+					_ = level.Error(c.Logger).Log("msg", "failed to save the state", "err", err)
+				}
+			case <-c.stateStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSavingState stops saving the state periodically and saves it a last time.
+func (c *WorkflowMetricsExporter) stopSavingState() error {
+	if c.stateStop != nil {
+		c.stateStopOnce.Do(func() { close(c.stateStop) })
+		<-c.stateDone
+	}
+	return c.saveState()
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WorkflowMetricsExporter_PersistsStateAcrossRestarts(t *testing.T) {
+	// Given
+	opts := server.Opts{
+		GitHubToken:         "state-secret",
+		DeduplicationWindow: time.Hour,
+		InFlightJobTTL:      time.Hour,
+		EventWorkers:        1,
+		EventQueueSize:      10,
+		StateStore:          server.StateStoreFile,
+		StatePath:           filepath.Join(t.TempDir(), "state", "state.json"),
+		StateCounters:       true,
+	}
+	body, err := json.Marshal(github.WorkflowJobEvent{
+		Action:      github.String("queued"),
+		WorkflowJob: &github.WorkflowJob{ID: github.Int64(1), Name: github.String("Test"), Labels: []string{"gpu", "linux", "self-hosted"}, Status: github.String("queued")},
+		Repo:        &github.Repository{Name: github.String("some-repo"), Owner: &github.User{Login: github.String("state-org")}},
+	})
+	require.NoError(t, err)
+	delivery := server.Delivery{ID: "first", Event: "workflow_job", Body: body}
+	deliver := func(exporter *server.WorkflowMetricsExporter) int {
+		req, err := delivery.Request("/", "state-secret")
+		require.NoError(t, err)
+		res := httptest.NewRecorder()
+		exporter.HandleGHWebHook(res, req)
+		return res.Code
+	}
+	jobStatus := `workflow_job_status_count{branch="",conclusion="",job_name="Test",org="state-org",repo="some-repo",runner_group="",status="queued",workflow_name=""}`
+
+	reg := prometheus.NewRegistry()
+	exporter, err := server.NewWorkflowMetricsExporterWithObserver(log.NewNopLogger(), opts, server.NewPrometheusObserver(reg, opts))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, deliver(exporter))
+	require.NoError(t, exporter.Shutdown(context.Background()))
+	require.FileExists(t, opts.StatePath)
+
+	// When
+	reg = prometheus.NewRegistry()
+	restarted, err := server.NewWorkflowMetricsExporterWithObserver(log.NewNopLogger(), opts, server.NewPrometheusObserver(reg, opts))
+	require.NoError(t, err)
+	deliver(restarted)
+	require.NoError(t, restarted.Shutdown(context.Background()))
+
+	// Then
+	expected := "# HELP workflow_job_status_count Count of workflow job events.\n# TYPE workflow_job_status_count counter\n" + jobStatus + " 1\n"
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "workflow_job_status_count"), "the counter is restored and the redelivery dropped")
+	queued := restarted.DeliveryMetrics().InFlightJobs(server.JobStateQueued).WithLabelValues("state-org", "some-repo", "", "gpu,linux,self-hosted")
+	assert.Equal(t, 1.0, testutil.ToFloat64(queued), "the queued job is tracked again")
+}
+
+func Test_fileStateStore_LoadsNothingBeforeTheFirstSave(t *testing.T) {
+	store := server.NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	state, err := store.Load()
+
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
+
+func Test_fileStateStore_RejectsCorruptedState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"saved_at":`), 0o600))
+
+	_, err := (server.NewFileStateStore(path)).Load()
+
+	assert.Error(t, err)
+}
+
+func Test_NewWorkflowMetricsExporter_FailsWhenTheStateCannotBeRestored(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"saved_at":`), 0o600))
+	_, opts := newTestRedisStore(t)
+	opts.StateStore = server.StateStoreFile
+	opts.StatePath = path
+	opts.EventWorkers = 1
+	opts.InFlightJobTTL = time.Hour
+
+	// When
+	exporter, err := server.NewWorkflowMetricsExporterWithObserver(log.NewNopLogger(), opts, server.NewPrometheusObserver(prometheus.NewRegistry(), opts))
+
+	// Then
+	assert.ErrorContains(t, err, "restoring the state")
+	assert.Nil(t, exporter)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// Events the synthetic deliveries can be built for.
+const (
+	SyntheticEventPing        = "ping"
+	SyntheticEventWorkflowJob = "workflow_job"
+	SyntheticEventWorkflowRun = "workflow_run"
+)
+
+// SyntheticEventOpts describes the workflow the synthetic deliveries are about.
+type SyntheticEventOpts struct {
+	Owner        string
+	Repo         string
+	Branch       string
+	WorkflowName string
+	JobName      string
+	RunnerName   string
+	RunnerLabels []string
+	Conclusion   string
+	// Time the job waits in the queue before it starts.
+	QueueDuration time.Duration
+	// Time the job, and the workflow run, take once started.
+	Duration time.Duration
+}
+
+// SyntheticDeliveries returns deliveries of event as GitHub would send them for a workflow that
+// completed at now: a ping, the queued, in_progress and completed actions of a job, or the
+// requested, in_progress and completed actions of a workflow run. The IDs are derived from now,
+// so that the deliveries of successive calls are not dropped as duplicates.
+func SyntheticDeliveries(event string, opts SyntheticEventOpts, now time.Time) ([]Delivery, error) {
+	completedAt := now.UTC().Truncate(time.Second)
+	startedAt := completedAt.Add(-opts.Duration)
+	createdAt := startedAt.Add(-opts.QueueDuration)
+
+	repository := &github.Repository{
+		Name:     github.String(opts.Repo),
+		FullName: github.String(opts.Owner + "/" + opts.Repo),
+		Owner:    &github.User{Login: github.String(opts.Owner)},
+	}
+	runID := completedAt.Unix()
+	jobID := completedAt.UnixNano()
+
+	var payloads []interface{}
+	switch event {
+	case SyntheticEventPing:
+		payloads = append(payloads, github.PingEvent{
+			Zen:    github.String("Keep it logically awesome."),
+			HookID: github.Int64(runID),
+			Repo:   repository,
+		})
+	case SyntheticEventWorkflowJob:
+		for _, status := range []string{"queued", "in_progress", "completed"} {
+			job := &github.WorkflowJob{
+				ID:           github.Int64(jobID),
+				RunID:        github.Int64(runID),
+				RunAttempt:   github.Int64(1),
+				Name:         github.String(opts.JobName),
+				WorkflowName: github.String(opts.WorkflowName),
+				HeadBranch:   github.String(opts.Branch),
+				Status:       github.String(status),
+				Labels:       opts.RunnerLabels,
+				CreatedAt:    &github.Timestamp{Time: createdAt},
+			}
+			if status != "queued" {
+				job.StartedAt = &github.Timestamp{Time: startedAt}
+				job.RunnerName = github.String(opts.RunnerName)
+			}
+			if status == "completed" {
+				job.CompletedAt = &github.Timestamp{Time: completedAt}
+				job.Conclusion = github.String(opts.Conclusion)
+			}
+			payloads = append(payloads, github.WorkflowJobEvent{Action: github.String(status), WorkflowJob: job, Repo: repository})
+		}
+	case SyntheticEventWorkflowRun:
+		for _, action := range []string{"requested", "in_progress", "completed"} {
+			status := action
+			if action == "requested" {
+				status = "queued"
+			}
+			run := &github.WorkflowRun{
+				ID:           github.Int64(runID),
+				Name:         github.String(opts.WorkflowName),
+				RunAttempt:   github.Int(1),
+				HeadBranch:   github.String(opts.Branch),
+				Status:       github.String(status),
+				CreatedAt:    &github.Timestamp{Time: createdAt},
+				RunStartedAt: &github.Timestamp{Time: createdAt},
+				UpdatedAt:    &github.Timestamp{Time: startedAt},
+				Repository:   repository,
+			}
+			if action == "completed" {
+				run.Conclusion = github.String(opts.Conclusion)
+				run.UpdatedAt = &github.Timestamp{Time: completedAt}
+			}
+			payloads = append(payloads, github.WorkflowRunEvent{
+				Action:      github.String(action),
+				Workflow:    &github.Workflow{Name: github.String(opts.WorkflowName)},
+				WorkflowRun: run,
+				Repo:        repository,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported event %q", event)
+	}
+
+	deliveries := make([]Delivery, 0, len(payloads))
+	for _, payload := range payloads {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		id, err := newDeliveryID()
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, Delivery{ID: id, Event: event, Body: body})
+	}
+	return deliveries, nil
+}
+
+// newDeliveryID returns a random GUID, like the X-GitHub-Delivery header of GitHub.
+func newDeliveryID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SyntheticDeliveries_ProduceWorkflowMetrics(t *testing.T) {
+	// Given
+	opts := server.SyntheticEventOpts{
+		Owner: "synthetic-org", Repo: "synthetic-repo", Branch: "release", WorkflowName: "Deploy", JobName: "smoke",
+		RunnerName: "runner-1", RunnerLabels: []string{"self-hosted"}, Conclusion: "failure",
+		QueueDuration: 10 * time.Second, Duration: time.Minute,
+	}
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	var deliveries []server.Delivery
+	for _, event := range []string{server.SyntheticEventPing, server.SyntheticEventWorkflowJob, server.SyntheticEventWorkflowRun} {
+		eventDeliveries, err := server.SyntheticDeliveries(event, opts, now)
+		require.NoError(t, err)
+		deliveries = append(deliveries, eventDeliveries...)
+	}
+	out := &bytes.Buffer{}
+
+	// When
+	rejected, err := server.NewReplayer(log.NewNopLogger(), server.Opts{}).ReplayInProcess(context.Background(), out, deliveries)
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 0, rejected)
+	assert.Len(t, deliveries, 7)
+	assert.Contains(t, out.String(), `workflow_job_duration_seconds_total{branch="release",conclusion="failure",job_name="smoke",org="synthetic-org",repo="synthetic-repo",runner_group="",status="completed",workflow_name="Deploy"} 60`)
+	assert.Contains(t, out.String(), `workflow_execution_time_seconds_sum{branch="release",conclusion="failure",org="synthetic-org",repo="synthetic-repo",workflow_name="Deploy"} 70`)
+}
+
+func Test_SyntheticDeliveries_UnsupportedEvent(t *testing.T) {
+	_, err := server.SyntheticDeliveries("push", server.SyntheticEventOpts{}, time.Now())
+
+	assert.Error(t, err)
+}
+
+func Test_Replayer_Send(t *testing.T) {
+	// Given
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exporter := &server.WorkflowMetricsExporter{Logger: log.NewNopLogger(), Opts: server.Opts{GitHubToken: "send-secret"}}
+		exporter.HandleGHWebHook(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	deliveries, err := server.SyntheticDeliveries(server.SyntheticEventPing, server.SyntheticEventOpts{Owner: "synthetic-org", Repo: "synthetic-repo"}, time.Now())
+	require.NoError(t, err)
+
+	// When
+	accepted, err := server.NewReplayer(log.NewNopLogger(), server.Opts{GitHubToken: "send-secret"}).Send(context.Background(), srv.Client(), srv.URL, deliveries[0])
+	require.NoError(t, err)
+	rejected, err := server.NewReplayer(log.NewNopLogger(), server.Opts{GitHubToken: "wrong-secret"}).Send(context.Background(), srv.Client(), srv.URL, deliveries[0])
+	require.NoError(t, err)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, accepted)
+	assert.Equal(t, http.StatusForbidden, rejected)
+}
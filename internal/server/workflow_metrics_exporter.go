@@ -2,20 +2,29 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cpanato/github_actions_exporter/model"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/go-github/v66/github"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // WorkflowMetricsExporter struct to hold some information
@@ -24,13 +33,156 @@ type WorkflowMetricsExporter struct {
 	Logger             log.Logger
 	Opts               Opts
 	PrometheusObserver WorkflowObserver
+
+	// deliveries holds the recently seen X-GitHub-Delivery GUIDs, nil when deduplication is disabled.
+	deliveries *expiringSet
+	// jobActions holds the recently seen (run_id, job_id, action) tuples of workflow_job events,
+	// nil when they are not deduplicated.
+	jobActions *expiringSet
+	// queue processes the events, nil when every event is processed in its own goroutine.
+	queue *eventQueue
+	// jobs tracks the queued and running jobs, nil when they are not tracked.
+	jobs *jobTracker
+	// queueTimes holds the job attempts whose queue time was observed, see queueTimeObserved.
+	queueTimes     *expiringSet
+	queueTimesOnce sync.Once
+	// completions holds the recently collected completions of workflow runs and jobs, so that a
+	// completion is collected once whether it is delivered or recovered by the reconciler. nil
+	// when the reconciler is disabled.
+	completions *expiringSet
+	// journal records the deliveries, nil when disabled.
+	journal *deliveryJournal
+	// shared shares the state above with the other replicas, nil outside of the HA mode.
+	shared sharedStore
+	// state persists the state above across restarts, nil when disabled.
+	state         stateStore
+	stateStop     chan struct{}
+	stateDone     chan struct{}
+	stateStopOnce sync.Once
+}
+
+const (
+	// retryAfterSeconds is sent in the Retry-After header when the event queue is full.
+	retryAfterSeconds = "10"
+
+	// Self-hosted runner jobs can be queued for up to 24 hours and run for up to 5 days, the queue
+	// time of a job attempt is remembered long enough to cover late in_progress and completed events.
+	queueTimeObservedTTL        = 6 * 24 * time.Hour
+	queueTimeObservedMaxEntries = 200000
+
+	// The collected completions are remembered for twice the reconciliation lookback, long enough
+	// for the reconciler and late deliveries not to collect them again.
+	completionsMaxEntries = 200000
+)
+
+func NewWorkflowMetricsExporter(logger log.Logger, opts Opts) (*WorkflowMetricsExporter, error) {
+	return newWorkflowMetricsExporter(logger, opts, NewPrometheusObserver(prometheus.DefaultRegisterer, opts))
 }
 
-func NewWorkflowMetricsExporter(logger log.Logger, opts Opts) *WorkflowMetricsExporter {
-	return &WorkflowMetricsExporter{
+// newWorkflowMetricsExporter returns an exporter passing its observations to observer. The state
+// is restored before any worker is started, so that nothing is left running when it can't be.
+func newWorkflowMetricsExporter(logger log.Logger, opts Opts, observer WorkflowObserver) (*WorkflowMetricsExporter, error) {
+	// The replicas would otherwise each collect every delivery, and all poll the GitHub API.
+	var shared sharedStore
+	if opts.HABackend != "" {
+		store, err := newSharedStore(opts)
+		if err != nil {
+			return nil, fmt.Errorf("creating the HA backend: %w", err)
+		}
+		shared = store
+	}
+
+	exporter := &WorkflowMetricsExporter{
 		Logger:             logger,
 		Opts:               opts,
-		PrometheusObserver: &PrometheusObserver{},
+		PrometheusObserver: observer,
+	}
+
+	if opts.DeduplicationWindow > 0 {
+		exporter.deliveries = newExpiringSet(opts.DeduplicationWindow, opts.DeduplicationMaxEntries)
+		if opts.DeduplicateJobActions {
+			exporter.jobActions = newExpiringSet(opts.DeduplicationWindow, opts.DeduplicationMaxEntries)
+		}
+	}
+
+	if opts.ReconcileInterval > 0 {
+		exporter.completions = newExpiringSet(2*opts.ReconcileLookback, completionsMaxEntries)
+	}
+
+	if opts.InFlightJobTTL > 0 {
+		exporter.jobs = newJobTracker(opts.InFlightJobTTL, exporter.deliveryMetrics())
+	}
+
+	if shared != nil {
+		exporter.shareState(shared)
+	}
+
+	if opts.StateStore != "" {
+		store, err := newStateStore(opts)
+		if err != nil {
+			exporter.closeShared()
+			return nil, fmt.Errorf("creating the state store: %w", err)
+		}
+		exporter.state = store
+		if err := exporter.restoreState(); err != nil {
+			exporter.closeShared()
+			return nil, fmt.Errorf("restoring the state: %w", err)
+		}
+	}
+
+	if opts.EventWorkers > 0 {
+		exporter.queue = newEventQueue(logger, opts.EventQueueSize, opts.EventWorkers, exporter.deliveryMetrics())
+	}
+
+	if opts.JournalDir != "" {
+		exporter.journal = newDeliveryJournal(logger, opts)
+	}
+
+	if exporter.jobs != nil {
+		exporter.jobs.Start(time.Minute)
+	}
+
+	if exporter.state != nil && opts.StateSaveInterval > 0 {
+		exporter.startSavingState(opts.StateSaveInterval)
+	}
+
+	return exporter, nil
+}
+
+// Shutdown waits for the queued events to be processed, and saves the state when it is persisted.
+func (c *WorkflowMetricsExporter) Shutdown(ctx context.Context) error {
+	defer c.closeShared()
+	if c.jobs != nil {
+		defer c.jobs.Stop()
+	}
+	if c.journal != nil {
+		defer c.journal.Close()
+	}
+	var err error
+	if c.queue != nil {
+		err = c.queue.Shutdown(ctx)
+	}
+	if c.state != nil {
+		// Saved once the queued events are processed.
+		if saveErr := c.stopSavingState(); saveErr != nil {
+			err = errors.Join(err, fmt.Errorf("saving the state: %w", saveErr))
+		}
+	}
+	return err
+}
+
+// deliveryMetrics returns the delivery metrics registered with the registry of the observer.
+func (c *WorkflowMetricsExporter) deliveryMetrics() *deliveryMetrics {
+	if observer, ok := c.PrometheusObserver.(*PrometheusObserver); ok {
+		return observer.delivery
+	}
+	return defaultDeliveryMetrics
+}
+
+// closeShared closes the shared store, if any.
+func (c *WorkflowMetricsExporter) closeShared() {
+	if c.shared != nil {
+		_ = c.shared.Close()
 	}
 }
 
@@ -44,16 +196,15 @@ func (c *WorkflowMetricsExporter) HandleGHWebHook(w http.ResponseWriter, r *http
 	}
 	defer r.Body.Close()
 
-	receivedHash := strings.SplitN(r.Header.Get("X-Hub-Signature"), "=", 2)
-	if receivedHash[0] != "sha1" {
-		_ = level.Error(c.Logger).Log("msg", "invalid webhook hash signature: SHA1")
-		w.WriteHeader(http.StatusForbidden)
-		return
+	if c.journal != nil {
+		journalWriter := &journalResponseWriter{ResponseWriter: w}
+		defer func() { c.journal.Record(r.Header, buf, journalWriter.status, journalWriter.result) }()
+		w = journalWriter
 	}
 
-	err = validateSignature(c.Opts.GitHubToken, receivedHash, buf)
+	err = c.verifyRequestSignature(r.Header, buf)
 	if err != nil {
-		_ = level.Error(c.Logger).Log("msg", "invalid token", "err", err)
+		_ = level.Error(c.Logger).Log("msg", "rejected webhook", "reason", signatureRejectReason(err), "err", err)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
@@ -61,6 +212,12 @@ func (c *WorkflowMetricsExporter) HandleGHWebHook(w http.ResponseWriter, r *http
 	_ = level.Debug(c.Logger).Log("msg", "received webhook", "payload", string(buf))
 
 	eventType := r.Header.Get("X-GitHub-Event")
+	deliveryID := r.Header.Get("X-GitHub-Delivery")
+	if c.deliveries != nil && deliveryID != "" && !c.deliveries.Add(deliveryID) {
+		c.acceptDuplicate(w, eventType, "delivery_id", "deliveryID", deliveryID)
+		return
+	}
+
 	switch eventType {
 	case "ping":
 		pingEvent := model.PingEventFromJSON(io.NopCloser(bytes.NewBuffer(buf)))
@@ -82,11 +239,38 @@ func (c *WorkflowMetricsExporter) HandleGHWebHook(w http.ResponseWriter, r *http
 			"action", event.GetAction(),
 			"workflow_name", event.GetWorkflowJob().GetWorkflowName(),
 			"job_name", event.GetWorkflowJob().GetName())
-		go c.CollectWorkflowJobEvent(event)
+		if c.jobActions != nil && !c.jobActions.Add(workflowJobActionKey(event)) {
+			c.acceptDuplicate(w, eventType, "job_action", "deliveryID", deliveryID, "jobId", event.GetWorkflowJob().GetID())
+			return
+		}
+		if !c.enqueue(eventType, func() { c.CollectWorkflowJobEvent(event) }) {
+			if c.jobActions != nil {
+				c.jobActions.Remove(workflowJobActionKey(event))
+			}
+			c.rejectQueueFull(w, eventType, deliveryID)
+			return
+		}
 	case "workflow_run":
 		event := model.WorkflowRunEventFromJSON(io.NopCloser(bytes.NewBuffer(buf)))
 		_ = level.Info(c.Logger).Log("msg", "got workflow_run event", "org", event.GetRepo().GetOwner().GetLogin(), "repo", event.GetRepo().GetName(), "branch", event.GetWorkflowRun().GetHeadBranch(), "workflow_name", event.GetWorkflow().GetName(), "runNumber", event.GetWorkflowRun().GetRunNumber(), "action", event.GetAction())
-		go c.CollectWorkflowRunEvent(event)
+		if !c.enqueue(eventType, func() { c.CollectWorkflowRunEvent(event) }) {
+			c.rejectQueueFull(w, eventType, deliveryID)
+			return
+		}
+	case "check_run":
+		event := model.CheckRunEventFromJSON(io.NopCloser(bytes.NewBuffer(buf)))
+		_ = level.Info(c.Logger).Log("msg", "got check_run event", "org", event.GetRepo().GetOwner().GetLogin(), "repo", event.GetRepo().GetName(), "branch", event.GetCheckRun().GetCheckSuite().GetHeadBranch(), "app", checkApp(event.GetCheckRun().GetApp()), "check_name", event.GetCheckRun().GetName(), "action", event.GetAction())
+		if !c.enqueue(eventType, func() { c.CollectCheckRunEvent(event) }) {
+			c.rejectQueueFull(w, eventType, deliveryID)
+			return
+		}
+	case "check_suite":
+		event := model.CheckSuiteEventFromJSON(io.NopCloser(bytes.NewBuffer(buf)))
+		_ = level.Info(c.Logger).Log("msg", "got check_suite event", "org", event.GetRepo().GetOwner().GetLogin(), "repo", event.GetRepo().GetName(), "branch", event.GetCheckSuite().GetHeadBranch(), "app", checkApp(event.GetCheckSuite().GetApp()), "action", event.GetAction())
+		if !c.enqueue(eventType, func() { c.CollectCheckSuiteEvent(event) }) {
+			c.rejectQueueFull(w, eventType, deliveryID)
+			return
+		}
 	default:
 		_ = level.Info(c.Logger).Log("msg", "not implemented", "eventType", eventType)
 		w.WriteHeader(http.StatusNotImplemented)
@@ -97,59 +281,181 @@ func (c *WorkflowMetricsExporter) HandleGHWebHook(w http.ResponseWriter, r *http
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (c *WorkflowMetricsExporter) CollectWorkflowJobEvent(event *github.WorkflowJobEvent) {
+// enqueue schedules the processing of an event and reports whether it was accepted.
+func (c *WorkflowMetricsExporter) enqueue(eventType string, process func()) bool {
+	if c.queue == nil {
+		go process()
+		return true
+	}
+	return c.queue.Enqueue(eventType, process)
+}
+
+// rejectQueueFull asks GitHub to retry a delivery later because the event queue is full. The
+// delivery is forgotten by the deduplication so that its redelivery is processed.
+func (c *WorkflowMetricsExporter) rejectQueueFull(w http.ResponseWriter, eventType, deliveryID string) {
+	if c.deliveries != nil {
+		c.deliveries.Remove(deliveryID)
+	}
+	_ = level.Warn(c.Logger).Log("msg", "event queue is full, rejecting delivery", "eventType", eventType, "deliveryID", deliveryID)
+
+	w.Header().Set("Retry-After", retryAfterSeconds)
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// collectWorkflowJobQueueTime observes the time a job waited for a runner, from its creation until
+// a runner picked it up. It is observed from the first in_progress or completed event received for
+// a job attempt, whichever comes first, and only once per attempt.
+func (c *WorkflowMetricsExporter) collectWorkflowJobQueueTime(event *github.WorkflowJobEvent, runnerLabels, runnerName string) {
+	workflowJob := event.GetWorkflowJob()
+	if workflowJob.CreatedAt == nil || workflowJob.StartedAt == nil {
+		_ = level.Debug(c.Logger).Log("msg", "unable to calculate job queue time as the event is missing timestamps", "jobId", workflowJob.GetID())
+		return
+	}
+	if workflowJob.GetRunnerID() == 0 && workflowJob.GetRunnerName() == "" {
+		// The job completed without ever being picked up by a runner, e.g. it was cancelled or skipped.
+		return
+	}
+
+	if workflowJob.GetID() != 0 && !c.queueTimeObserved().Add(fmt.Sprintf("%d/%d", workflowJob.GetID(), workflowJob.GetRunAttempt())) {
+		return
+	}
+
+	queuedSeconds := math.Max(0, workflowJob.GetStartedAt().Time.Sub(workflowJob.GetCreatedAt().Time).Seconds())
+	c.PrometheusObserver.ObserveWorkflowJobDuration(event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName(), workflowJob.GetHeadBranch(), "queued",
+		workflowJob.GetRunnerGroupName(), runnerLabels, runnerName, workflowJob.GetWorkflowName(), workflowJob.GetName(), queuedSeconds)
+}
+
+// queueTimeObserved returns the job attempts whose queue time was already observed.
+func (c *WorkflowMetricsExporter) queueTimeObserved() *expiringSet {
+	c.queueTimesOnce.Do(func() {
+		c.queueTimes = newExpiringSet(queueTimeObservedTTL, queueTimeObservedMaxEntries)
+	})
+	return c.queueTimes
+}
+
+// collectWorkflowJobSteps observes the duration and conclusion of the completed steps of a job
+// whose names are selected by the step name allow-list and regex.
+func (c *WorkflowMetricsExporter) collectWorkflowJobSteps(org, repo, workflowName, jobName string, steps []*github.TaskStep) {
+	for _, step := range steps {
+		stepName := step.GetName()
+		if step.GetStatus() != "completed" || !c.Opts.stepSelected(stepName) {
+			continue
+		}
+
+		conclusion := step.GetConclusion()
+		if step.StartedAt != nil && step.CompletedAt != nil {
+			stepSeconds := math.Max(0, step.GetCompletedAt().Time.Sub(step.GetStartedAt().Time).Seconds())
+			c.PrometheusObserver.ObserveWorkflowJobStepDuration(org, repo, workflowName, jobName, stepName, conclusion, stepSeconds)
+		}
+		c.PrometheusObserver.CountWorkflowJobStepConclusion(org, repo, workflowName, jobName, stepName, conclusion)
+	}
+}
+
+// acceptDuplicate acknowledges a delivery that was already processed without collecting it again.
+func (c *WorkflowMetricsExporter) acceptDuplicate(w http.ResponseWriter, eventType, reason string, keyvals ...interface{}) {
+	c.deliveryMetrics().duplicateDeliveries.WithLabelValues(eventType, reason).Inc()
+	setDeliveryResult(w, deliveryResultDuplicate)
+	_ = level.Info(c.Logger).Log(append([]interface{}{"msg", "ignoring duplicate delivery", "eventType", eventType, "reason", reason}, keyvals...)...)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte(`{"status": "duplicate"}`))
+}
+
+// workflowJobActionKey identifies a state transition of a single workflow job.
+func workflowJobActionKey(event *github.WorkflowJobEvent) string {
+	return fmt.Sprintf("%d/%d/%s", event.GetWorkflowJob().GetRunID(), event.GetWorkflowJob().GetID(), event.GetAction())
+}
+
+// workflowJobCompletionKey identifies the completion of a workflow job, whose ID is unique per attempt.
+func workflowJobCompletionKey(job *github.WorkflowJob) string {
+	return fmt.Sprintf("job/%d", job.GetID())
+}
+
+// workflowRunCompletionKey identifies the completion of an attempt of a workflow run.
+func workflowRunCompletionKey(run *github.WorkflowRun) string {
+	return fmt.Sprintf("run/%d/%d", run.GetID(), run.GetRunAttempt())
+}
+
+// claimCompletion reports whether the completion identified by key is collected for the first
+// time, and remembers it.
+func (c *WorkflowMetricsExporter) claimCompletion(key string) bool {
+	if c.completions == nil {
+		return true
+	}
+	return c.completions.Add(key)
+}
+
+// completionCollected reports whether the completion identified by key was already collected.
+func (c *WorkflowMetricsExporter) completionCollected(key string) bool {
+	return c.completions != nil && c.completions.Contains(key)
+}
+
+// CollectWorkflowJobEvent collects a workflow_job event and reports whether it was collected, i.e.
+// it is not a completion that was already collected.
+func (c *WorkflowMetricsExporter) CollectWorkflowJobEvent(event *github.WorkflowJobEvent) bool {
 	repo := event.GetRepo().GetName()
 	org := event.GetRepo().GetOwner().GetLogin()
 	branch := event.WorkflowJob.GetHeadBranch()
 	action := event.GetAction()
 
 	workflowJob := event.GetWorkflowJob()
+	if action == "completed" && !c.claimCompletion(workflowJobCompletionKey(workflowJob)) {
+		_ = level.Debug(c.Logger).Log("msg", "ignoring already collected workflow job completion", "jobId", workflowJob.GetID())
+		return false
+	}
 	runnerGroup := workflowJob.GetRunnerGroupName()
+	runnerLabels := sortedRunnerLabels(workflowJob)
+	runnerName := workflowJob.GetRunnerName()
 	conclusion := workflowJob.GetConclusion()
 	status := workflowJob.GetStatus()
 	workflowName := workflowJob.GetWorkflowName()
 	jobName := workflowJob.GetName()
 
+	if c.jobs != nil {
+		c.jobs.Observe(event)
+	}
+
 	switch action {
 	case "queued":
 		// Do nothing.
 	case "in_progress":
-
-		if len(workflowJob.Steps) == 0 {
-			_ = level.Debug(c.Logger).Log("msg", "unable to calculate job duration of in_progress event as event has no steps")
-			break
-		}
-
-		if len(workflowJob.Steps) > 1 {
-			// If there are more than one steps, we are receiving an update of an already running job.
-			// Don't count the queued time again since it's already running.
-			break
-		}
-
-		firstStep := workflowJob.Steps[0]
-		queuedSeconds := firstStep.StartedAt.Time.Sub(workflowJob.GetStartedAt().Time).Seconds()
-		c.PrometheusObserver.ObserveWorkflowJobDuration(org, repo, branch, "queued", runnerGroup, workflowName, jobName, math.Max(0, queuedSeconds))
+		c.collectWorkflowJobQueueTime(event, runnerLabels, runnerName)
 	case "completed":
+		c.collectWorkflowJobQueueTime(event, runnerLabels, runnerName)
+
 		if workflowJob.StartedAt == nil || workflowJob.CompletedAt == nil {
 			_ = level.Debug(c.Logger).Log("msg", "unable to calculate job duration of completed event steps are missing timestamps")
 			break
 		}
 
 		jobSeconds := math.Max(0, workflowJob.GetCompletedAt().Time.Sub(workflowJob.GetStartedAt().Time).Seconds())
-		c.PrometheusObserver.ObserveWorkflowJobDuration(org, repo, branch, "in_progress", runnerGroup, workflowName, jobName, jobSeconds)
-		c.PrometheusObserver.CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName, jobSeconds)
+		c.PrometheusObserver.ObserveWorkflowJobDuration(org, repo, branch, "in_progress", runnerGroup, runnerLabels, runnerName, workflowName, jobName, jobSeconds)
+		c.PrometheusObserver.CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName, jobSeconds)
 	}
 
-	c.PrometheusObserver.CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName)
+	if action == "completed" {
+		c.collectWorkflowJobSteps(org, repo, workflowName, jobName, workflowJob.Steps)
+	}
+
+	c.PrometheusObserver.CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName)
+	return true
 }
 
-func (c *WorkflowMetricsExporter) CollectWorkflowRunEvent(event *github.WorkflowRunEvent) {
+// CollectWorkflowRunEvent collects a workflow_run event and reports whether it was collected, i.e.
+// it is not a completion that was already collected.
+func (c *WorkflowMetricsExporter) CollectWorkflowRunEvent(event *github.WorkflowRunEvent) bool {
 	repo := event.GetRepo().GetName()
 	org := event.GetRepo().GetOwner().GetLogin()
 	branch := event.GetWorkflowRun().GetHeadBranch()
 	workflowName := event.GetWorkflow().GetName()
 	conclusion := event.GetWorkflowRun().GetConclusion()
 
+	if event.GetAction() == "completed" && !c.claimCompletion(workflowRunCompletionKey(event.GetWorkflowRun())) {
+		_ = level.Debug(c.Logger).Log("msg", "ignoring already collected workflow run completion", "runId", event.GetWorkflowRun().GetID())
+		return false
+	}
+
 	if event.GetAction() == "completed" {
 		seconds := event.GetWorkflowRun().UpdatedAt.Time.Sub(event.GetWorkflowRun().RunStartedAt.Time).Seconds()
 		c.PrometheusObserver.ObserveWorkflowRunDuration(org, repo, branch, workflowName, conclusion, seconds)
@@ -157,21 +463,199 @@ func (c *WorkflowMetricsExporter) CollectWorkflowRunEvent(event *github.Workflow
 
 	status := event.GetWorkflowRun().GetStatus()
 	c.PrometheusObserver.CountWorkflowRunStatus(org, repo, branch, status, conclusion, workflowName)
+	return true
 }
 
-// validateSignature validate the incoming github event.
-func validateSignature(gitHubToken string, receivedHash []string, bodyBuffer []byte) error {
-	hash := hmac.New(sha1.New, []byte(gitHubToken))
-	if _, err := hash.Write(bodyBuffer); err != nil {
-		msg := fmt.Sprintf("Cannot compute the HMAC for request: %s\n", err)
-		return errors.New(msg)
+var (
+	errMissingSignature     = errors.New("no X-Hub-Signature-256 header")
+	errLegacySignature      = errors.New("only a legacy SHA-1 X-Hub-Signature header was sent and SHA-1 is not allowed")
+	errUnsupportedSignature = errors.New("unsupported signature algorithm")
+	errMalformedSignature   = errors.New("signature is not valid hex")
+	errSignatureMismatch    = errors.New("signature does not match the payload")
+	errUnknownTarget        = errors.New("no webhook secret configured for the delivery target")
+	errTargetOwnerMismatch  = errors.New("delivery is about an owner the target is not bound to")
+)
+
+// signatureRejectReason maps a signature verification error to a short reason used in logs.
+func signatureRejectReason(err error) string {
+	switch {
+	case errors.Is(err, errMissingSignature):
+		return "missing_signature"
+	case errors.Is(err, errLegacySignature):
+		return "sha1_not_allowed"
+	case errors.Is(err, errUnsupportedSignature):
+		return "unsupported_algorithm"
+	case errors.Is(err, errMalformedSignature):
+		return "malformed_signature"
+	case errors.Is(err, errSignatureMismatch):
+		return "signature_mismatch"
+	case errors.Is(err, errUnknownTarget):
+		return "unknown_target"
+	case errors.Is(err, errTargetOwnerMismatch):
+		return "owner_mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// verifyRequestSignature checks the webhook signature headers against every secret configured
+// for the delivery target. X-Hub-Signature-256 is always preferred; the SHA-1 X-Hub-Signature
+// header is only used when no SHA-256 signature was sent and the legacy fallback is enabled.
+func (c *WorkflowMetricsExporter) verifyRequestSignature(header http.Header, body []byte) error {
+	target, secrets, err := c.Opts.secretsForDelivery(header, body)
+	if err != nil {
+		return err
+	}
+
+	var index int
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		index, err = validateSignature(sha256.New, "sha256", secrets, signature, body)
+	} else if signature := header.Get("X-Hub-Signature"); signature != "" {
+		if !c.Opts.AllowSHA1Signature {
+			return errLegacySignature
+		}
+		index, err = validateSignature(sha1.New, "sha1", secrets, signature, body)
+	} else {
+		return errMissingSignature
+	}
+	if err != nil {
+		return err
 	}
 
-	expectedHash := hex.EncodeToString(hash.Sum(nil))
-	if receivedHash[1] != expectedHash {
-		msg := fmt.Sprintf("Expected Hash does not match the received hash: %s\n", expectedHash)
-		return errors.New(msg)
+	// The hook and installation target headers are not signed, the owner of the verified payload
+	// must be one the target they selected is bound to.
+	if kind, _, _ := strings.Cut(target, ":"); kind == WebhookTargetHook || kind == WebhookTargetInstallationTarget {
+		if owner := deliveryOwner(body); owner != "" && !slices.Contains(c.Opts.TargetOwners[target], strings.ToLower(owner)) {
+			return fmt.Errorf("%w: %s", errTargetOwnerMismatch, owner)
+		}
 	}
 
+	c.deliveryMetrics().secretValidations.WithLabelValues(target, strconv.Itoa(index)).Inc()
 	return nil
 }
+
+// secretsForDelivery selects the secrets a delivery must be signed with. Secrets configured for
+// the hook ID take precedence over the installation target ID, which in turn take precedence over
+// the repository owner. Deliveries that match no target are rejected, unless they fall back to
+// the global secrets.
+func (o Opts) secretsForDelivery(header http.Header, body []byte) (string, []string, error) {
+	if len(o.TargetGitHubTokens) == 0 {
+		return defaultWebhookTarget, o.webhookSecrets(), nil
+	}
+
+	candidates := []string{
+		webhookTargetKey(WebhookTargetHook, header.Get("X-GitHub-Hook-ID")),
+		webhookTargetKey(WebhookTargetInstallationTarget, header.Get("X-GitHub-Hook-Installation-Target-ID")),
+		webhookTargetKey(WebhookTargetOwner, deliveryOwner(body)),
+	}
+	for _, key := range candidates {
+		if secrets := o.TargetGitHubTokens[key]; len(secrets) > 0 {
+			return key, secrets, nil
+		}
+	}
+
+	if secrets := o.webhookSecrets(); o.TargetFallbackGlobalTokens && len(secrets) > 0 {
+		return defaultWebhookTarget, secrets, nil
+	}
+
+	return "", nil, errUnknownTarget
+}
+
+// deliveryOwner returns the login of the repository owner, or of the organization, that sent
+// the delivery. The payload is not verified yet, so it is only used to pick the secret.
+func deliveryOwner(body []byte) string {
+	var payload struct {
+		Repository struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repository"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+
+	if payload.Repository.Owner.Login != "" {
+		return payload.Repository.Owner.Login
+	}
+	return payload.Organization.Login
+}
+
+func (c *WorkflowMetricsExporter) CollectCheckRunEvent(event *github.CheckRunEvent) {
+	repo := event.GetRepo().GetName()
+	org := event.GetRepo().GetOwner().GetLogin()
+	checkRun := event.GetCheckRun()
+	branch := checkRun.GetCheckSuite().GetHeadBranch()
+	app := checkApp(checkRun.GetApp())
+	checkName := checkRun.GetName()
+	conclusion := checkRun.GetConclusion()
+
+	if event.GetAction() == "completed" {
+		if checkRun.StartedAt == nil || checkRun.CompletedAt == nil {
+			_ = level.Debug(c.Logger).Log("msg", "unable to calculate check run duration as it is missing timestamps")
+		} else {
+			seconds := math.Max(0, checkRun.GetCompletedAt().Time.Sub(checkRun.GetStartedAt().Time).Seconds())
+			c.PrometheusObserver.ObserveCheckRunDuration(org, repo, branch, app, checkName, conclusion, seconds)
+		}
+	}
+
+	c.PrometheusObserver.CountCheckRunStatus(org, repo, branch, checkRun.GetStatus(), conclusion, app, checkName)
+}
+
+func (c *WorkflowMetricsExporter) CollectCheckSuiteEvent(event *github.CheckSuiteEvent) {
+	repo := event.GetRepo().GetName()
+	org := event.GetRepo().GetOwner().GetLogin()
+	checkSuite := event.GetCheckSuite()
+	branch := checkSuite.GetHeadBranch()
+	app := checkApp(checkSuite.GetApp())
+	conclusion := checkSuite.GetConclusion()
+
+	if event.GetAction() == "completed" {
+		if checkSuite.CreatedAt == nil || checkSuite.UpdatedAt == nil {
+			_ = level.Debug(c.Logger).Log("msg", "unable to calculate check suite duration as it is missing timestamps")
+		} else {
+			seconds := math.Max(0, checkSuite.GetUpdatedAt().Time.Sub(checkSuite.GetCreatedAt().Time).Seconds())
+			c.PrometheusObserver.ObserveCheckSuiteDuration(org, repo, branch, app, conclusion, seconds)
+		}
+	}
+
+	c.PrometheusObserver.CountCheckSuiteStatus(org, repo, branch, checkSuite.GetStatus(), conclusion, app)
+}
+
+// checkApp returns the label identifying the app that created a check, e.g. github-actions or buildkite.
+func checkApp(app *github.App) string {
+	if slug := app.GetSlug(); slug != "" {
+		return slug
+	}
+	return app.GetName()
+}
+
+// validateSignature validates a `<algorithm>=<hex digest>` signature of the incoming github event
+// and returns the index of the secret that produced it.
+func validateSignature(newHash func() hash.Hash, algorithm string, secrets []string, signature string, body []byte) (int, error) {
+	prefix, digest, found := strings.Cut(signature, "=")
+	if !found || prefix != algorithm {
+		return -1, fmt.Errorf("%w: %q", errUnsupportedSignature, prefix)
+	}
+
+	receivedMAC, err := hex.DecodeString(digest)
+	if err != nil {
+		return -1, fmt.Errorf("%w: %w", errMalformedSignature, err)
+	}
+
+	for i, secret := range secrets {
+		mac := hmac.New(newHash, []byte(secret))
+		if _, err := mac.Write(body); err != nil {
+			return -1, fmt.Errorf("cannot compute the HMAC for request: %w", err)
+		}
+
+		if hmac.Equal(receivedMAC, mac.Sum(nil)) {
+			return i, nil
+		}
+	}
+
+	return -1, errSignatureMismatch
+}
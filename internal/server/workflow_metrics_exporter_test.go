@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"testing"
 	"time"
 
@@ -52,6 +55,170 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_RejectsInvalidSignature(t *tes
 	assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
 }
 
+func Test_WorkflowMetricsExporter_HandleGHWebHook_SignatureHeaders(t *testing.T) {
+	payload := []byte(`{}`)
+	validSHA256 := signPayload(t, sha256.New, "sha256", webhookSecret, payload)
+	validSHA1 := signPayload(t, sha1.New, "sha1", webhookSecret, payload)
+
+	tests := []struct {
+		name      string
+		allowSHA1 bool
+		sha256    string
+		sha1      string
+		expected  int
+	}{
+		{name: "no signature", expected: http.StatusForbidden},
+		{name: "no signature with sha1 allowed", allowSHA1: true, expected: http.StatusForbidden},
+		{name: "valid sha256", sha256: validSHA256, expected: http.StatusNotImplemented},
+		{name: "invalid sha256", sha256: signPayload(t, sha256.New, "sha256", "wrong", payload), expected: http.StatusForbidden},
+		{name: "malformed sha256", sha256: "sha256=not-hex", expected: http.StatusForbidden},
+		{name: "sha256 header with sha1 prefix", sha256: validSHA1, expected: http.StatusForbidden},
+		{name: "valid sha1 not allowed", sha1: validSHA1, expected: http.StatusForbidden},
+		{name: "valid sha1 allowed", allowSHA1: true, sha1: validSHA1, expected: http.StatusNotImplemented},
+		{name: "invalid sha1 allowed", allowSHA1: true, sha1: "sha1=incorrect", expected: http.StatusForbidden},
+		{name: "sha256 preferred over valid sha1", allowSHA1: true, sha256: "sha256=00", sha1: validSHA1, expected: http.StatusForbidden},
+		{name: "valid sha256 with invalid sha1", sha256: validSHA256, sha1: "sha1=incorrect", expected: http.StatusNotImplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			subject := server.WorkflowMetricsExporter{
+				Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+				Opts: server.Opts{
+					GitHubToken:        webhookSecret,
+					AllowSHA1Signature: tt.allowSHA1,
+				},
+			}
+
+			req, err := http.NewRequest("POST", "/anything", bytes.NewReader(payload))
+			require.NoError(t, err)
+			if tt.sha256 != "" {
+				req.Header.Add("X-Hub-Signature-256", tt.sha256)
+			}
+			if tt.sha1 != "" {
+				req.Header.Add("X-Hub-Signature", tt.sha1)
+			}
+
+			// When
+			res := httptest.NewRecorder()
+			subject.HandleGHWebHook(res, req)
+
+			// Then
+			assert.Equal(t, tt.expected, res.Result().StatusCode)
+		})
+	}
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_AcceptsAdditionalSecrets(t *testing.T) {
+	payload := []byte(`{}`)
+
+	tests := []struct {
+		name     string
+		secret   string
+		expected int
+	}{
+		{name: "primary secret", secret: webhookSecret, expected: http.StatusNotImplemented},
+		{name: "first additional secret", secret: "old-secret", expected: http.StatusNotImplemented},
+		{name: "second additional secret", secret: "older-secret", expected: http.StatusNotImplemented},
+		{name: "unknown secret", secret: "retired-secret", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			subject := server.WorkflowMetricsExporter{
+				Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+				Opts: server.Opts{
+					GitHubToken:            webhookSecret,
+					AdditionalGitHubTokens: []string{"old-secret", "", "older-secret"},
+				},
+			}
+
+			req, err := http.NewRequest("POST", "/anything", bytes.NewReader(payload))
+			require.NoError(t, err)
+			req.Header.Add("X-Hub-Signature-256", signPayload(t, sha256.New, "sha256", tt.secret, payload))
+
+			// When
+			res := httptest.NewRecorder()
+			subject.HandleGHWebHook(res, req)
+
+			// Then
+			assert.Equal(t, tt.expected, res.Result().StatusCode)
+		})
+	}
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_SecretsPerTarget(t *testing.T) {
+	targetTokens := map[string][]string{}
+	for _, target := range []struct{ kind, value, secret string }{
+		{server.WebhookTargetHook, "42", "hook-secret"},
+		{server.WebhookTargetInstallationTarget, "1234", "installation-secret"},
+		{server.WebhookTargetOwner, "Some-Org", "org-secret"},
+	} {
+		key, err := server.WebhookTargetKey(target.kind, target.value)
+		require.NoError(t, err)
+		targetTokens[key] = []string{target.secret}
+	}
+	targetOwners := map[string][]string{"hook:42": {"some-org"}, "installation-target:1234": {"some-org", "other-org"}}
+
+	tests := []struct {
+		name         string
+		globalSecret string
+		fallback     bool
+		secret       string
+		hookID       string
+		targetID     string
+		owner        string
+		expected     int
+	}{
+		{name: "hook secret", secret: "hook-secret", hookID: "42", targetID: "1234", owner: "some-org", expected: http.StatusNotImplemented},
+		{name: "hook secret takes precedence", secret: "installation-secret", hookID: "42", targetID: "1234", expected: http.StatusForbidden},
+		{name: "installation target secret", secret: "installation-secret", hookID: "7", targetID: "1234", expected: http.StatusNotImplemented},
+		{name: "owner secret", secret: "org-secret", owner: "some-org", expected: http.StatusNotImplemented},
+		{name: "secret of another target", secret: "org-secret", hookID: "42", expected: http.StatusForbidden},
+		{name: "unknown target", secret: "org-secret", owner: "another-org", expected: http.StatusForbidden},
+		{name: "unknown target with global secret", globalSecret: webhookSecret, secret: webhookSecret, owner: "another-org", expected: http.StatusForbidden},
+		{name: "unknown target with global secret fallback", globalSecret: webhookSecret, fallback: true, secret: webhookSecret, owner: "another-org", expected: http.StatusNotImplemented},
+		{name: "known target ignores global secret", globalSecret: webhookSecret, fallback: true, secret: webhookSecret, owner: "some-org", expected: http.StatusForbidden},
+		{name: "hook secret for another owner", secret: "hook-secret", hookID: "42", owner: "other-org", expected: http.StatusForbidden},
+		{name: "installation target secret for a bound owner", secret: "installation-secret", targetID: "1234", owner: "Other-Org", expected: http.StatusNotImplemented},
+		{name: "installation target secret for another owner", secret: "installation-secret", targetID: "1234", owner: "another-org", expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			subject := server.WorkflowMetricsExporter{
+				Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+				Opts: server.Opts{
+					GitHubToken:                tt.globalSecret,
+					TargetGitHubTokens:         targetTokens,
+					TargetOwners:               targetOwners,
+					TargetFallbackGlobalTokens: tt.fallback,
+				},
+			}
+
+			payload, err := json.Marshal(github.PingEvent{
+				Repo: &github.Repository{Owner: &github.User{Login: &tt.owner}},
+			})
+			require.NoError(t, err)
+			req, err := http.NewRequest("POST", "/anything", bytes.NewReader(payload))
+			require.NoError(t, err)
+			req.Header.Add("X-Hub-Signature-256", signPayload(t, sha256.New, "sha256", tt.secret, payload))
+			req.Header.Add("X-GitHub-Hook-ID", tt.hookID)
+			req.Header.Add("X-GitHub-Hook-Installation-Target-ID", tt.targetID)
+
+			// When
+			res := httptest.NewRecorder()
+			subject.HandleGHWebHook(res, req)
+
+			// Then
+			assert.Equal(t, tt.expected, res.Result().StatusCode)
+		})
+	}
+}
+
 func Test_GHActionExporter_HandleGHWebHook_ValidatesValidSignature(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
@@ -165,7 +332,7 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobQueuedEvent(t *testing.T)
 	}, 50*time.Millisecond)
 }
 
-func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t *testing.T) {
+func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEvent(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
 	subject := server.WorkflowMetricsExporter{
@@ -180,9 +347,10 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t
 	org := "someone"
 	branch := "some-branch"
 	expectedDuration := 10.0
-	jobStartedAt := time.Unix(1650308740, 0)
-	stepStartedAt := jobStartedAt.Add(time.Duration(expectedDuration) * time.Second)
+	jobCreatedAt := time.Unix(1650308740, 0)
+	jobStartedAt := jobCreatedAt.Add(time.Duration(expectedDuration) * time.Second)
 	runnerGroupName := "runner-group"
+	runnerName := "runner-1"
 	action := "in_progress"
 	statusInProgress := "in_progress"
 	workflowName := "Build and test"
@@ -197,16 +365,19 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t
 			},
 		},
 		WorkflowJob: &github.WorkflowJob{
+			ID:         github.Int64(1),
 			HeadBranch: &branch,
 			Status:     &statusInProgress,
+			CreatedAt:  &github.Timestamp{Time: jobCreatedAt},
 			StartedAt:  &github.Timestamp{Time: jobStartedAt},
 			Steps: []*github.TaskStep{
 				{
-					StartedAt: &github.Timestamp{Time: stepStartedAt},
+					StartedAt: &github.Timestamp{Time: jobStartedAt.Add(time.Second)},
 					Status:    &statusInProgress,
 				},
 			},
 			RunnerGroupName: &runnerGroupName,
+			RunnerName:      &runnerName,
 			WorkflowName:    &workflowName,
 			Name:            &jobName,
 		},
@@ -225,6 +396,7 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t
 		branch:       branch,
 		state:        "queued",
 		runnerGroup:  runnerGroupName,
+		runnerName:   runnerName,
 		seconds:      expectedDuration,
 		workflowName: workflowName,
 		jobName:      jobName,
@@ -234,6 +406,7 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t
 		repo:         repo,
 		branch:       branch,
 		runnerGroup:  runnerGroupName,
+		runnerName:   runnerName,
 		status:       action,
 		conclusion:   "",
 		workflowName: workflowName,
@@ -241,7 +414,7 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventFirstStep(t
 	}, 50*time.Millisecond)
 }
 
-func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventSecondStep(t *testing.T) {
+func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventQueueTimeObservedOnce(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
 	subject := server.WorkflowMetricsExporter{
@@ -252,65 +425,71 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobInProgressEventSecondStep(
 		PrometheusObserver: observer,
 	}
 
-	repo := "some-repo"
-	org := "someone"
-	branch := "some-branch"
-	expectedDuration := 10.0
-	jobStartedAt := time.Unix(1650308740, 0)
-	stepStartedAt := jobStartedAt.Add(time.Duration(expectedDuration) * time.Second)
-	runnerGroupName := "runner-group"
-	action := "in_progress"
-	statusInProgress := "in_progress"
-	statusCompleted := "completed"
-	workflowName := "Build and test"
-	jobName := "Test"
-
-	event := github.WorkflowJobEvent{
-		Action: &action,
-		Repo: &github.Repository{
-			Name: &repo,
-			Owner: &github.User{
-				Login: &org,
+	jobCreatedAt := time.Unix(1650308740, 0)
+	jobStartedAt := jobCreatedAt.Add(10 * time.Second)
+	event := func(steps int, runAttempt int64) github.WorkflowJobEvent {
+		workflowJob := &github.WorkflowJob{
+			ID:           github.Int64(1),
+			RunAttempt:   github.Int64(runAttempt),
+			Status:       github.String("in_progress"),
+			CreatedAt:    &github.Timestamp{Time: jobCreatedAt},
+			StartedAt:    &github.Timestamp{Time: jobStartedAt},
+			RunnerName:   github.String("runner-1"),
+			WorkflowName: github.String("Build and test"),
+			Name:         github.String("Test"),
+		}
+		for i := 0; i < steps; i++ {
+			workflowJob.Steps = append(workflowJob.Steps, &github.TaskStep{
+				StartedAt: &github.Timestamp{Time: jobStartedAt.Add(time.Duration(i) * time.Second)},
+				Status:    github.String("in_progress"),
+			})
+		}
+
+		return github.WorkflowJobEvent{
+			Action: github.String("in_progress"),
+			Repo: &github.Repository{
+				Name:  github.String("some-repo"),
+				Owner: &github.User{Login: github.String("someone")},
 			},
-		},
-		WorkflowJob: &github.WorkflowJob{
-			HeadBranch: &branch,
-			Status:     &statusInProgress,
-			StartedAt:  &github.Timestamp{Time: jobStartedAt},
-			Steps: []*github.TaskStep{
-				{
-					StartedAt: &github.Timestamp{Time: stepStartedAt},
-					Status:    &statusCompleted,
-				},
-				{
-					StartedAt: &github.Timestamp{Time: stepStartedAt.Add(5 * time.Second)},
-					Status:    &statusInProgress,
-				},
-			},
-			RunnerGroupName: &runnerGroupName,
-			WorkflowName:    &workflowName,
-			Name:            &jobName,
-		},
+			WorkflowJob: workflowJob,
+		}
+	}
+	expectedObservation := workflowJobObservation{
+		org:          "someone",
+		repo:         "some-repo",
+		state:        "queued",
+		runnerName:   "runner-1",
+		workflowName: "Build and test",
+		jobName:      "Test",
+		seconds:      10,
 	}
-	req := testWebhookRequest(t, "/anything", "workflow_job", event)
 
 	// When
 	res := httptest.NewRecorder()
-	subject.HandleGHWebHook(res, req)
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event(1, 1)))
 
 	// Then
 	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertWorkflowJobObservation(expectedObservation, 50*time.Millisecond)
+	<-observer.workflowJobStatusCounted
+
+	// When an update of the running job is received
+	res = httptest.NewRecorder()
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event(2, 1)))
+
+	// Then the queue time is not observed again
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
 	observer.assertNoWorkflowJobDurationObservation(50 * time.Millisecond)
-	observer.assertWorkflowJobStatusCount(workflowJobStatusCount{
-		org:          org,
-		repo:         repo,
-		branch:       branch,
-		runnerGroup:  runnerGroupName,
-		status:       action,
-		conclusion:   "",
-		workflowName: workflowName,
-		jobName:      jobName,
-	}, 50*time.Millisecond)
+	<-observer.workflowJobStatusCounted
+
+	// When the job is re-run
+	res = httptest.NewRecorder()
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event(1, 2)))
+
+	// Then the queue time of the new attempt is observed
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertWorkflowJobObservation(expectedObservation, 50*time.Millisecond)
+	<-observer.workflowJobStatusCounted
 }
 
 func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWithNegativeDuration(t *testing.T) {
@@ -329,8 +508,9 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWith
 	branch := "some-branch"
 	expectedDuration := 10.0
 	jobStartedAt := time.Unix(1650308740, 0)
-	stepStartedAt := jobStartedAt.Add(-1 * time.Duration(expectedDuration) * time.Second)
+	jobCreatedAt := jobStartedAt.Add(time.Duration(expectedDuration) * time.Second)
 	runnerGroupName := "runner-group"
+	runnerName := "runner-1"
 	action := "in_progress"
 	status := "in_progress"
 	workflowName := "Build and test"
@@ -345,15 +525,12 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWith
 			},
 		},
 		WorkflowJob: &github.WorkflowJob{
-			HeadBranch: &branch,
-			Status:     &status,
-			StartedAt:  &github.Timestamp{Time: jobStartedAt},
-			Steps: []*github.TaskStep{
-				{
-					StartedAt: &github.Timestamp{Time: stepStartedAt},
-				},
-			},
+			HeadBranch:      &branch,
+			Status:          &status,
+			CreatedAt:       &github.Timestamp{Time: jobCreatedAt},
+			StartedAt:       &github.Timestamp{Time: jobStartedAt},
 			RunnerGroupName: &runnerGroupName,
+			RunnerName:      &runnerName,
 			WorkflowName:    &workflowName,
 			Name:            &jobName,
 		},
@@ -372,6 +549,7 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWith
 		branch:       branch,
 		state:        "queued",
 		runnerGroup:  runnerGroupName,
+		runnerName:   runnerName,
 		workflowName: workflowName,
 		jobName:      jobName,
 		seconds:      0,
@@ -381,6 +559,7 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWith
 		repo:         repo,
 		branch:       branch,
 		runnerGroup:  runnerGroupName,
+		runnerName:   runnerName,
 		status:       action,
 		conclusion:   "",
 		workflowName: workflowName,
@@ -388,6 +567,126 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobInProgressEventWith
 	}, 50*time.Millisecond)
 }
 
+func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobQueueTimeWithOutOfOrderEvents(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	jobCreatedAt := time.Unix(1650308740, 0)
+	jobStartedAt := jobCreatedAt.Add(20 * time.Second)
+	jobCompletedAt := jobStartedAt.Add(5 * time.Second)
+	event := func(action string) github.WorkflowJobEvent {
+		workflowJob := &github.WorkflowJob{
+			ID:           github.Int64(1),
+			RunAttempt:   github.Int64(1),
+			Status:       github.String(action),
+			CreatedAt:    &github.Timestamp{Time: jobCreatedAt},
+			StartedAt:    &github.Timestamp{Time: jobStartedAt},
+			RunnerID:     github.Int64(42),
+			WorkflowName: github.String("Build and test"),
+			Name:         github.String("Test"),
+		}
+		if action == "completed" {
+			workflowJob.Conclusion = github.String("success")
+			workflowJob.CompletedAt = &github.Timestamp{Time: jobCompletedAt}
+		}
+
+		return github.WorkflowJobEvent{
+			Action: github.String(action),
+			Repo: &github.Repository{
+				Name:  github.String("some-repo"),
+				Owner: &github.User{Login: github.String("someone")},
+			},
+			WorkflowJob: workflowJob,
+		}
+	}
+
+	// When the completed event is received first
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event("completed")))
+
+	// Then the queue time is observed from the completed event
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertWorkflowJobObservation(workflowJobObservation{
+		org:          "someone",
+		repo:         "some-repo",
+		state:        "queued",
+		workflowName: "Build and test",
+		jobName:      "Test",
+		seconds:      20,
+	}, 50*time.Millisecond)
+	observer.assertWorkflowJobObservation(workflowJobObservation{
+		org:          "someone",
+		repo:         "some-repo",
+		state:        "in_progress",
+		workflowName: "Build and test",
+		jobName:      "Test",
+		seconds:      5,
+	}, 50*time.Millisecond)
+	<-observer.workflowJobDurationCounted
+	<-observer.workflowJobStatusCounted
+
+	// When the in_progress event is received afterwards
+	res = httptest.NewRecorder()
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event("in_progress")))
+
+	// Then the queue time is not observed again
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertNoWorkflowJobDurationObservation(50 * time.Millisecond)
+	<-observer.workflowJobStatusCounted
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobCompletedWithoutRunner(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	cancelledAt := time.Unix(1650308740, 0)
+	event := github.WorkflowJobEvent{
+		Action: github.String("completed"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		WorkflowJob: &github.WorkflowJob{
+			ID:           github.Int64(1),
+			Status:       github.String("completed"),
+			Conclusion:   github.String("cancelled"),
+			CreatedAt:    &github.Timestamp{Time: cancelledAt.Add(-time.Minute)},
+			StartedAt:    &github.Timestamp{Time: cancelledAt},
+			CompletedAt:  &github.Timestamp{Time: cancelledAt},
+			WorkflowName: github.String("Build and test"),
+			Name:         github.String("Test"),
+		},
+	}
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, testWebhookRequest(t, "/anything", "workflow_job", event))
+
+	// Then only the job duration is observed
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertWorkflowJobObservation(workflowJobObservation{
+		org:          "someone",
+		repo:         "some-repo",
+		state:        "in_progress",
+		workflowName: "Build and test",
+		jobName:      "Test",
+	}, 50*time.Millisecond)
+}
+
 func Test_GHActionExporter_HandleGHWebHook_WorkflowJobCompletedEvent(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
@@ -472,6 +771,52 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobCompletedEvent(t *testing.
 	}, 50*time.Millisecond)
 }
 
+func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobRunnerDimensions(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	event := github.WorkflowJobEvent{
+		Action: github.String("queued"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		WorkflowJob: &github.WorkflowJob{
+			Status:          github.String("queued"),
+			Labels:          []string{"xlarge", "arm64", "self-hosted"},
+			RunnerName:      github.String("runner-1"),
+			RunnerGroupName: github.String("runner-group"),
+			WorkflowName:    github.String("Build and test"),
+			Name:            github.String("Test"),
+		},
+	}
+	req := testWebhookRequest(t, "/anything", "workflow_job", event)
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertWorkflowJobStatusCount(workflowJobStatusCount{
+		org:          "someone",
+		repo:         "some-repo",
+		status:       "queued",
+		runnerGroup:  "runner-group",
+		runnerLabels: "arm64,self-hosted,xlarge",
+		runnerName:   "runner-1",
+		workflowName: "Build and test",
+		jobName:      "Test",
+	}, 50*time.Millisecond)
+}
+
 func Test_GHActionExporter_HandleGHWebHook_WorkflowJobCompletedEvent_WithNoStartedAt(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
@@ -591,6 +936,77 @@ func Test_GHActionExporter_HandleGHWebHook_WorkflowJobCompletedEvent_WithNoCompl
 	}, 50*time.Millisecond)
 }
 
+func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowJobCompletedEventSteps(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken:   webhookSecret,
+			StepNames:     []string{"Set up job"},
+			StepNameRegex: regexp.MustCompile(`^Run `),
+		},
+		PrometheusObserver: observer,
+	}
+
+	startedAt := time.Unix(1650308740, 0)
+	step := func(name, status, conclusion string, startOffset, seconds int) *github.TaskStep {
+		return &github.TaskStep{
+			Name:        github.String(name),
+			Status:      github.String(status),
+			Conclusion:  github.String(conclusion),
+			StartedAt:   &github.Timestamp{Time: startedAt.Add(time.Duration(startOffset) * time.Second)},
+			CompletedAt: &github.Timestamp{Time: startedAt.Add(time.Duration(startOffset+seconds) * time.Second)},
+		}
+	}
+
+	event := github.WorkflowJobEvent{
+		Action: github.String("completed"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		WorkflowJob: &github.WorkflowJob{
+			Status:       github.String("completed"),
+			Conclusion:   github.String("failure"),
+			StartedAt:    &github.Timestamp{Time: startedAt},
+			CompletedAt:  &github.Timestamp{Time: startedAt.Add(60 * time.Second)},
+			WorkflowName: github.String("Build and test"),
+			Name:         github.String("Test"),
+			Steps: []*github.TaskStep{
+				step("Set up job", "completed", "success", 0, 3),
+				step("Checkout", "completed", "success", 3, 2),
+				step("Run tests", "completed", "failure", 5, 50),
+				step("Run lint", "queued", "", 55, 0),
+			},
+		},
+	}
+	req := testWebhookRequest(t, "/anything", "workflow_job", event)
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	for _, expected := range []workflowJobStepObservation{
+		{stepName: "Set up job", conclusion: "success", seconds: 3},
+		{stepName: "Run tests", conclusion: "failure", seconds: 50},
+	} {
+		expected.org, expected.repo, expected.workflowName, expected.jobName = "someone", "some-repo", "Build and test", "Test"
+		observer.assertWorkflowJobStepObservation(expected, 50*time.Millisecond)
+		observer.assertWorkflowJobStepConclusionCount(workflowJobStepConclusionCount{
+			org:          expected.org,
+			repo:         expected.repo,
+			workflowName: expected.workflowName,
+			jobName:      expected.jobName,
+			stepName:     expected.stepName,
+			conclusion:   expected.conclusion,
+		}, 50*time.Millisecond)
+	}
+	observer.assertNoWorkflowJobStepObservation(50 * time.Millisecond)
+}
+
 func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowRunCompleted(t *testing.T) {
 	// Given
 	observer := NewTestPrometheusObserver(t)
@@ -706,6 +1122,254 @@ func Test_WorkflowMetricsExporter_HandleGHWebHook_WorkflowRunEventOtherThanCompl
 	observer.assertNoWorkflowRunStatusCount(1 * time.Second)
 }
 
+func Test_WorkflowMetricsExporter_HandleGHWebHook_DropsRedeliveries(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject, err := server.NewWorkflowMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), server.Opts{
+		GitHubToken:         webhookSecret,
+		DeduplicationWindow: time.Hour,
+	})
+	require.NoError(t, err)
+	subject.PrometheusObserver = observer
+
+	event := github.WorkflowRunEvent{
+		Action:      github.String("requested"),
+		Repo:        &github.Repository{Name: github.String("some-repo"), Owner: &github.User{Login: github.String("someone")}},
+		Workflow:    &github.Workflow{Name: github.String("myworkflow")},
+		WorkflowRun: &github.WorkflowRun{Status: github.String("queued")},
+	}
+
+	// When
+	res := httptest.NewRecorder()
+	req := testWebhookRequest(t, "/anything", "workflow_run", event)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	subject.HandleGHWebHook(res, req)
+
+	redelivery := httptest.NewRecorder()
+	req = testWebhookRequest(t, "/anything", "workflow_run", event)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	subject.HandleGHWebHook(redelivery, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	assert.Equal(t, http.StatusAccepted, redelivery.Result().StatusCode)
+	assert.Equal(t, `{"status": "duplicate"}`, redelivery.Body.String())
+	observer.assertWorkflowRunStatusCount(workflowRunStatusCount{
+		org:          "someone",
+		repo:         "some-repo",
+		status:       "queued",
+		workflowName: "myworkflow",
+	}, 50*time.Millisecond)
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-observer.workflowRunStatusCounted:
+		t.Fatal("expected the redelivery not to be counted")
+	}
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_DropsRepeatedJobActions(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject, err := server.NewWorkflowMetricsExporter(log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)), server.Opts{
+		GitHubToken:           webhookSecret,
+		DeduplicationWindow:   time.Hour,
+		DeduplicateJobActions: true,
+	})
+	require.NoError(t, err)
+	subject.PrometheusObserver = observer
+
+	event := github.WorkflowJobEvent{
+		Action: github.String("queued"),
+		Repo:   &github.Repository{Name: github.String("some-repo"), Owner: &github.User{Login: github.String("someone")}},
+		WorkflowJob: &github.WorkflowJob{
+			ID:           github.Int64(2),
+			RunID:        github.Int64(1),
+			Status:       github.String("queued"),
+			WorkflowName: github.String("Build and test"),
+			Name:         github.String("Test"),
+		},
+	}
+
+	// When
+	res := httptest.NewRecorder()
+	req := testWebhookRequest(t, "/anything", "workflow_job", event)
+	req.Header.Set("X-GitHub-Delivery", "first-delivery")
+	subject.HandleGHWebHook(res, req)
+
+	retry := httptest.NewRecorder()
+	req = testWebhookRequest(t, "/anything", "workflow_job", event)
+	req.Header.Set("X-GitHub-Delivery", "second-delivery")
+	subject.HandleGHWebHook(retry, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	assert.Equal(t, `{"status": "duplicate"}`, retry.Body.String())
+	observer.assertWorkflowJobStatusCount(workflowJobStatusCount{
+		org:          "someone",
+		repo:         "some-repo",
+		status:       "queued",
+		workflowName: "Build and test",
+		jobName:      "Test",
+	}, 50*time.Millisecond)
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-observer.workflowJobStatusCounted:
+		t.Fatal("expected the repeated job action not to be counted")
+	}
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_CheckRunCompleted(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	startedAt := time.Unix(1650308740, 0)
+	event := github.CheckRunEvent{
+		Action: github.String("completed"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		CheckRun: &github.CheckRun{
+			Name:        github.String("buildkite/pipeline"),
+			Status:      github.String("completed"),
+			Conclusion:  github.String("failure"),
+			StartedAt:   &github.Timestamp{Time: startedAt},
+			CompletedAt: &github.Timestamp{Time: startedAt.Add(42 * time.Second)},
+			CheckSuite:  &github.CheckSuite{HeadBranch: github.String("some-branch")},
+			App:         &github.App{Slug: github.String("buildkite"), Name: github.String("Buildkite")},
+		},
+	}
+	req := testWebhookRequest(t, "/anything", "check_run", event)
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertCheckRunObservation(checkRunObservation{
+		org:        "someone",
+		repo:       "some-repo",
+		branch:     "some-branch",
+		app:        "buildkite",
+		checkName:  "buildkite/pipeline",
+		conclusion: "failure",
+		seconds:    42,
+	}, 50*time.Millisecond)
+	observer.assertCheckRunStatusCount(checkRunStatusCount{
+		org:        "someone",
+		repo:       "some-repo",
+		branch:     "some-branch",
+		status:     "completed",
+		conclusion: "failure",
+		app:        "buildkite",
+		checkName:  "buildkite/pipeline",
+	}, 50*time.Millisecond)
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_CheckRunCreated(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	event := github.CheckRunEvent{
+		Action: github.String("created"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		CheckRun: &github.CheckRun{
+			Name:       github.String("ci/circleci: build"),
+			Status:     github.String("queued"),
+			CheckSuite: &github.CheckSuite{HeadBranch: github.String("some-branch")},
+			App:        &github.App{Name: github.String("CircleCI Checks")},
+		},
+	}
+	req := testWebhookRequest(t, "/anything", "check_run", event)
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertCheckRunStatusCount(checkRunStatusCount{
+		org:       "someone",
+		repo:      "some-repo",
+		branch:    "some-branch",
+		status:    "queued",
+		app:       "CircleCI Checks",
+		checkName: "ci/circleci: build",
+	}, 50*time.Millisecond)
+	observer.assertNoCheckRunObservation(50 * time.Millisecond)
+}
+
+func Test_WorkflowMetricsExporter_HandleGHWebHook_CheckSuiteCompleted(t *testing.T) {
+	// Given
+	observer := NewTestPrometheusObserver(t)
+	subject := server.WorkflowMetricsExporter{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
+		Opts: server.Opts{
+			GitHubToken: webhookSecret,
+		},
+		PrometheusObserver: observer,
+	}
+
+	createdAt := time.Unix(1650308740, 0)
+	event := github.CheckSuiteEvent{
+		Action: github.String("completed"),
+		Repo: &github.Repository{
+			Name:  github.String("some-repo"),
+			Owner: &github.User{Login: github.String("someone")},
+		},
+		CheckSuite: &github.CheckSuite{
+			HeadBranch: github.String("some-branch"),
+			Status:     github.String("completed"),
+			Conclusion: github.String("success"),
+			CreatedAt:  &github.Timestamp{Time: createdAt},
+			UpdatedAt:  &github.Timestamp{Time: createdAt.Add(90 * time.Second)},
+			App:        &github.App{Slug: github.String("github-actions")},
+		},
+	}
+	req := testWebhookRequest(t, "/anything", "check_suite", event)
+
+	// When
+	res := httptest.NewRecorder()
+	subject.HandleGHWebHook(res, req)
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.Result().StatusCode)
+	observer.assertCheckSuiteObservation(checkSuiteObservation{
+		org:        "someone",
+		repo:       "some-repo",
+		branch:     "some-branch",
+		app:        "github-actions",
+		conclusion: "success",
+		seconds:    90,
+	}, 50*time.Millisecond)
+	observer.assertCheckSuiteStatusCount(checkSuiteStatusCount{
+		org:        "someone",
+		repo:       "some-repo",
+		branch:     "some-branch",
+		status:     "completed",
+		conclusion: "success",
+		app:        "github-actions",
+	}, 50*time.Millisecond)
+}
+
 func testWebhookRequest(t *testing.T, url, event string, payload interface{}) *http.Request {
 	b, err := json.Marshal(payload)
 	require.NoError(t, err)
@@ -719,24 +1383,37 @@ func testWebhookRequest(t *testing.T, url, event string, payload interface{}) *h
 }
 
 func addValidSignatureHeader(t *testing.T, req *http.Request, payload []byte) {
-	h := hmac.New(sha1.New, []byte(webhookSecret))
+	req.Header.Add("X-Hub-Signature-256", signPayload(t, sha256.New, "sha256", webhookSecret, payload))
+}
+
+func signPayload(t *testing.T, newHash func() hash.Hash, algorithm, secret string, payload []byte) string {
+	h := hmac.New(newHash, []byte(secret))
 	_, err := h.Write(payload)
 	require.NoError(t, err)
 
-	req.Header.Add("X-Hub-Signature", fmt.Sprintf("sha1=%s", hex.EncodeToString(h.Sum(nil))))
+	return fmt.Sprintf("%s=%s", algorithm, hex.EncodeToString(h.Sum(nil)))
 }
 
 type workflowJobObservation struct {
-	org, repo, branch, state, runnerGroup, workflowName, jobName string
-	seconds                                                      float64
+	org, repo, branch, state, runnerGroup, runnerLabels, runnerName, workflowName, jobName string
+	seconds                                                                                float64
 }
 type workflowJobStatusCount struct {
-	org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string
+	org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string
 }
 
 type workflowJobDurationCount struct {
-	org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string
-	seconds                                                                   float64
+	org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string
+	seconds                                                                                             float64
+}
+
+type workflowJobStepObservation struct {
+	org, repo, workflowName, jobName, stepName, conclusion string
+	seconds                                                float64
+}
+
+type workflowJobStepConclusionCount struct {
+	org, repo, workflowName, jobName, stepName, conclusion string
 }
 
 type workflowRunObservation struct {
@@ -748,6 +1425,24 @@ type workflowRunStatusCount struct {
 	org, repo, branch, status, conclusion, workflowName string
 }
 
+type checkRunObservation struct {
+	org, repo, branch, app, checkName, conclusion string
+	seconds                                       float64
+}
+
+type checkRunStatusCount struct {
+	org, repo, branch, status, conclusion, app, checkName string
+}
+
+type checkSuiteObservation struct {
+	org, repo, branch, app, conclusion string
+	seconds                            float64
+}
+
+type checkSuiteStatusCount struct {
+	org, repo, branch, status, conclusion, app string
+}
+
 var _ server.WorkflowObserver = (*TestPrometheusObserver)(nil)
 
 type TestPrometheusObserver struct {
@@ -755,8 +1450,14 @@ type TestPrometheusObserver struct {
 	workFlowJobDurationObserved chan workflowJobObservation
 	workflowJobStatusCounted    chan workflowJobStatusCount
 	workflowJobDurationCounted  chan workflowJobDurationCount
+	workflowJobStepObserved     chan workflowJobStepObservation
+	workflowJobStepCounted      chan workflowJobStepConclusionCount
 	workflowRunObserved         chan workflowRunObservation
 	workflowRunStatusCounted    chan workflowRunStatusCount
+	checkRunObserved            chan checkRunObservation
+	checkRunStatusCounted       chan checkRunStatusCount
+	checkSuiteObserved          chan checkSuiteObservation
+	checkSuiteStatusCounted     chan checkSuiteStatusCount
 }
 
 func NewTestPrometheusObserver(t *testing.T) *TestPrometheusObserver {
@@ -765,25 +1466,33 @@ func NewTestPrometheusObserver(t *testing.T) *TestPrometheusObserver {
 		workFlowJobDurationObserved: make(chan workflowJobObservation, 1),
 		workflowJobStatusCounted:    make(chan workflowJobStatusCount, 1),
 		workflowJobDurationCounted:  make(chan workflowJobDurationCount, 1),
+		workflowJobStepObserved:     make(chan workflowJobStepObservation, 10),
+		workflowJobStepCounted:      make(chan workflowJobStepConclusionCount, 10),
 		workflowRunObserved:         make(chan workflowRunObservation, 1),
 		workflowRunStatusCounted:    make(chan workflowRunStatusCount, 1),
+		checkRunObserved:            make(chan checkRunObservation, 1),
+		checkRunStatusCounted:       make(chan checkRunStatusCount, 1),
+		checkSuiteObserved:          make(chan checkSuiteObservation, 1),
+		checkSuiteStatusCounted:     make(chan checkSuiteStatusCount, 1),
 	}
 }
 
-func (o *TestPrometheusObserver) ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, workflowName, jobName string, seconds float64) {
+func (o *TestPrometheusObserver) ObserveWorkflowJobDuration(org, repo, branch, state, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64) {
 	o.workFlowJobDurationObserved <- workflowJobObservation{
 		org:          org,
 		repo:         repo,
 		branch:       branch,
 		state:        state,
 		runnerGroup:  runnerGroup,
+		runnerLabels: runnerLabels,
+		runnerName:   runnerName,
 		workflowName: workflowName,
 		jobName:      jobName,
 		seconds:      seconds,
 	}
 }
 
-func (o *TestPrometheusObserver) CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string) {
+func (o *TestPrometheusObserver) CountWorkflowJobStatus(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string) {
 	o.workflowJobStatusCounted <- workflowJobStatusCount{
 		org:          org,
 		repo:         repo,
@@ -791,12 +1500,14 @@ func (o *TestPrometheusObserver) CountWorkflowJobStatus(org, repo, branch, statu
 		status:       status,
 		conclusion:   conclusion,
 		runnerGroup:  runnerGroup,
+		runnerLabels: runnerLabels,
+		runnerName:   runnerName,
 		workflowName: workflowName,
 		jobName:      jobName,
 	}
 }
 
-func (o *TestPrometheusObserver) CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, workflowName, jobName string, seconds float64) {
+func (o *TestPrometheusObserver) CountWorkflowJobDuration(org, repo, branch, status, conclusion, runnerGroup, runnerLabels, runnerName, workflowName, jobName string, seconds float64) {
 	o.workflowJobDurationCounted <- workflowJobDurationCount{
 		org:          org,
 		repo:         repo,
@@ -804,12 +1515,37 @@ func (o *TestPrometheusObserver) CountWorkflowJobDuration(org, repo, branch, sta
 		status:       status,
 		conclusion:   conclusion,
 		runnerGroup:  runnerGroup,
+		runnerLabels: runnerLabels,
+		runnerName:   runnerName,
+		workflowName: workflowName,
+		jobName:      jobName,
+		seconds:      seconds,
+	}
+}
+
+func (o *TestPrometheusObserver) ObserveWorkflowJobStepDuration(org, repo, workflowName, jobName, stepName, conclusion string, seconds float64) {
+	o.workflowJobStepObserved <- workflowJobStepObservation{
+		org:          org,
+		repo:         repo,
 		workflowName: workflowName,
 		jobName:      jobName,
+		stepName:     stepName,
+		conclusion:   conclusion,
 		seconds:      seconds,
 	}
 }
 
+func (o *TestPrometheusObserver) CountWorkflowJobStepConclusion(org, repo, workflowName, jobName, stepName, conclusion string) {
+	o.workflowJobStepCounted <- workflowJobStepConclusionCount{
+		org:          org,
+		repo:         repo,
+		workflowName: workflowName,
+		jobName:      jobName,
+		stepName:     stepName,
+		conclusion:   conclusion,
+	}
+}
+
 func (o *TestPrometheusObserver) ObserveWorkflowRunDuration(org, repo, branch, workflowName, conclusion string, seconds float64) {
 	o.workflowRunObserved <- workflowRunObservation{
 		org:          org,
@@ -832,6 +1568,52 @@ func (o *TestPrometheusObserver) CountWorkflowRunStatus(org, repo, branch, statu
 	}
 }
 
+func (o *TestPrometheusObserver) ObserveCheckRunDuration(org, repo, branch, app, checkName, conclusion string, seconds float64) {
+	o.checkRunObserved <- checkRunObservation{
+		org:        org,
+		repo:       repo,
+		branch:     branch,
+		app:        app,
+		checkName:  checkName,
+		conclusion: conclusion,
+		seconds:    seconds,
+	}
+}
+
+func (o *TestPrometheusObserver) CountCheckRunStatus(org, repo, branch, status, conclusion, app, checkName string) {
+	o.checkRunStatusCounted <- checkRunStatusCount{
+		org:        org,
+		repo:       repo,
+		branch:     branch,
+		status:     status,
+		conclusion: conclusion,
+		app:        app,
+		checkName:  checkName,
+	}
+}
+
+func (o *TestPrometheusObserver) ObserveCheckSuiteDuration(org, repo, branch, app, conclusion string, seconds float64) {
+	o.checkSuiteObserved <- checkSuiteObservation{
+		org:        org,
+		repo:       repo,
+		branch:     branch,
+		app:        app,
+		conclusion: conclusion,
+		seconds:    seconds,
+	}
+}
+
+func (o *TestPrometheusObserver) CountCheckSuiteStatus(org, repo, branch, status, conclusion, app string) {
+	o.checkSuiteStatusCounted <- checkSuiteStatusCount{
+		org:        org,
+		repo:       repo,
+		branch:     branch,
+		status:     status,
+		conclusion: conclusion,
+		app:        app,
+	}
+}
+
 func (o *TestPrometheusObserver) assertNoWorkflowJobDurationObservation(timeout time.Duration) {
 	select {
 	case <-time.After(timeout):
@@ -867,6 +1649,32 @@ func (o *TestPrometheusObserver) assertWorkflowJobDurationCount(expected workflo
 	}
 }
 
+func (o *TestPrometheusObserver) assertWorkflowJobStepObservation(expected workflowJobStepObservation, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.workflowJobStepObserved:
+		assert.Equal(o.t, expected, observed)
+	}
+}
+
+func (o *TestPrometheusObserver) assertWorkflowJobStepConclusionCount(expected workflowJobStepConclusionCount, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.workflowJobStepCounted:
+		assert.Equal(o.t, expected, observed)
+	}
+}
+
+func (o *TestPrometheusObserver) assertNoWorkflowJobStepObservation(timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+	case <-o.workflowJobStepObserved:
+		o.t.Fatal("expected no observation but an observation occurred")
+	}
+}
+
 func (o *TestPrometheusObserver) assertWorkflowRunObservation(expected workflowRunObservation, timeout time.Duration) {
 	select {
 	case <-time.After(timeout):
@@ -892,3 +1700,47 @@ func (o *TestPrometheusObserver) assertNoWorkflowRunStatusCount(timeout time.Dur
 		o.t.Fatal("expected no observation but an observation occurred")
 	}
 }
+
+func (o *TestPrometheusObserver) assertCheckRunObservation(expected checkRunObservation, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.checkRunObserved:
+		assert.Equal(o.t, expected, observed)
+	}
+}
+
+func (o *TestPrometheusObserver) assertCheckRunStatusCount(expected checkRunStatusCount, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.checkRunStatusCounted:
+		assert.Equal(o.t, expected, observed)
+	}
+}
+
+func (o *TestPrometheusObserver) assertNoCheckRunObservation(timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+	case <-o.checkRunObserved:
+		o.t.Fatal("expected no observation but an observation occurred")
+	}
+}
+
+func (o *TestPrometheusObserver) assertCheckSuiteObservation(expected checkSuiteObservation, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.checkSuiteObserved:
+		assert.Equal(o.t, expected, observed)
+	}
+}
+
+func (o *TestPrometheusObserver) assertCheckSuiteStatusCount(expected checkSuiteStatusCount, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+		o.t.Fatal("expected observation but none occurred")
+	case observed := <-o.checkSuiteStatusCounted:
+		assert.Equal(o.t, expected, observed)
+	}
+}
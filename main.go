@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/cpanato/github_actions_exporter/internal/server"
@@ -19,15 +22,68 @@ import (
 )
 
 var (
+	serveCommand = kingpin.Command("serve", "Receive the GitHub webhooks and export the metrics, the default command.").Default()
+
 	listenAddressMetrics        = kingpin.Flag("web.listen-address", "Address to listen on for metrics.").Default(":9101").String()
 	listenAddressIngress        = kingpin.Flag("web.listen-address-ingress", "Address to listen on for web interface and receive webhook.").Default(":8065").String()
 	metricsPath                 = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 	ghWebHookPath               = kingpin.Flag("web.gh-webhook-path", "Path that will be called by the GitHub webhook.").Default("/gh_event").String()
 	githubWebhookToken          = kingpin.Flag("gh.github-webhook-token", "GitHub Webhook Token.").Envar("GITHUB_WEBHOOK_TOKEN").Default("").String()
+	githubWebhookExtraTokens    = kingpin.Flag("gh.github-webhook-additional-token", "Additional GitHub Webhook Token that is accepted, e.g. while rotating secrets. Can be repeated.").Envar("GITHUB_WEBHOOK_ADDITIONAL_TOKENS").Strings()
+	githubWebhookTokenFile      = kingpin.Flag("gh.github-webhook-token-file", "File with additional GitHub Webhook Tokens that are accepted, one per line.").Envar("GITHUB_WEBHOOK_TOKEN_FILE").Default("").String()
+	githubWebhookTargetTokens   = kingpin.Flag("gh.github-webhook-target-token", "GitHub Webhook Token for a single delivery target, as <hook|installation-target>:<id>@<owner>[,<owner>...]=<token> or owner:<login>=<token>. Can be repeated.").Envar("GITHUB_WEBHOOK_TARGET_TOKENS").Strings()
+	githubWebhookTargetFile     = kingpin.Flag("gh.github-webhook-target-token-file", "File with GitHub Webhook Tokens per delivery target, one <hook|installation-target>:<id>@<owner>[,<owner>...]=<token> or owner:<login>=<token> per line.").Envar("GITHUB_WEBHOOK_TARGET_TOKEN_FILE").Default("").String()
+	githubWebhookTargetFallback = kingpin.Flag("gh.github-webhook-target-fallback", "Verify the deliveries that match no delivery target with the global GitHub Webhook Tokens instead of rejecting them.").Envar("GITHUB_WEBHOOK_TARGET_FALLBACK").Default("false").Bool()
+	githubWebhookAllowSHA1      = kingpin.Flag("gh.github-webhook-allow-sha1", "Accept the legacy SHA-1 X-Hub-Signature header when a delivery has no X-Hub-Signature-256 header.").Envar("GITHUB_WEBHOOK_ALLOW_SHA1").Default("false").Bool()
+	webhookDedupWindow          = kingpin.Flag("gh.webhook-dedup-window", "Time window in which redeliveries of an already processed X-GitHub-Delivery are dropped. 0 disables deduplication.").Envar("WEBHOOK_DEDUP_WINDOW").Default("24h").Duration()
+	webhookDedupMaxEntries      = kingpin.Flag("gh.webhook-dedup-max-entries", "Maximum number of deliveries remembered for deduplication.").Envar("WEBHOOK_DEDUP_MAX_ENTRIES").Default("100000").Int()
+	webhookDedupJobActions      = kingpin.Flag("gh.webhook-dedup-job-actions", "Also drop workflow_job deliveries repeating an already processed (run_id, job_id, action), even with a new X-GitHub-Delivery.").Envar("WEBHOOK_DEDUP_JOB_ACTIONS").Default("false").Bool()
+	inFlightJobTTL              = kingpin.Flag("gh.in-flight-job-ttl", "Time after which a queued or running workflow job without any update stops being counted in workflow_jobs_queued and workflow_jobs_in_progress. 0 disables them.").Envar("IN_FLIGHT_JOB_TTL").Default("24h").Duration()
+	jobRunnerLabels             = kingpin.Flag("gh.job-runner-labels", "Label the workflow job metrics with the sorted runner labels requested by the job (runner_labels).").Envar("JOB_RUNNER_LABELS").Default("false").Bool()
+	jobRunnerName               = kingpin.Flag("gh.job-runner-name", "Label the workflow job metrics with the name of the runner that ran the job (runner_name).").Envar("JOB_RUNNER_NAME").Default("false").Bool()
+	stepNames                   = kingpin.Flag("gh.step-name", "Name of a workflow job step exported by the step metrics. Can be repeated. When neither this nor --gh.step-name-regex are set, every step is exported.").Envar("STEP_NAMES").Strings()
+	stepNameRegex               = kingpin.Flag("gh.step-name-regex", "Regular expression matching the names of the workflow job steps exported by the step metrics.").Envar("STEP_NAME_REGEX").Regexp()
+	eventWorkers                = kingpin.Flag("web.event-workers", "Number of workers processing webhook events. 0 processes each event in a goroutine of its own, without queue.").Envar("EVENT_WORKERS").Default("4").Int()
+	eventQueueSize              = kingpin.Flag("web.event-queue-size", "Number of webhook events that can wait for a worker before deliveries are rejected with 503.").Envar("EVENT_QUEUE_SIZE").Default("1000").Int()
+	shutdownTimeout             = kingpin.Flag("web.shutdown-timeout", "Maximum time to wait for queued webhook events to be processed on shutdown.").Envar("SHUTDOWN_TIMEOUT").Default("30s").Duration()
 	gitHubAPIToken              = kingpin.Flag("gh.github-api-token", "GitHub API Token.").Envar("GITHUB_API_TOKEN").Default("").String()
-	gitHubOrg                   = kingpin.Flag("gh.github-org", "GitHub Organization.").Envar("GITHUB_ORG").Default("").String()
-	gitHubUser                  = kingpin.Flag("gh.github-user", "GitHub User.").Default("").String()
+	gitHubAppID                 = kingpin.Flag("gh.github-app-id", "ID of the GitHub App used to authenticate GitHub API calls instead of the GitHub API Token.").Envar("GITHUB_APP_ID").Default("0").Int64()
+	gitHubAppPrivateKeyFile     = kingpin.Flag("gh.github-app-private-key-file", "File with the PEM encoded private key of the GitHub App.").Envar("GITHUB_APP_PRIVATE_KEY_FILE").Default("").String()
+	gitHubAppInstallationID     = kingpin.Flag("gh.github-app-installation-id", "ID of the GitHub App installation. When not set, the installation of each organization or user is discovered.").Envar("GITHUB_APP_INSTALLATION_ID").Default("0").Int64()
+	gitHubAPIURL                = kingpin.Flag("gh.api-url", "GitHub Enterprise Server API URL, e.g. https://github.example.com/api/v3/. Defaults to github.com.").Envar("GITHUB_API_URL").Default("").String()
+	gitHubUploadURL             = kingpin.Flag("gh.upload-url", "GitHub Enterprise Server upload URL. Derived from --gh.api-url when not set.").Envar("GITHUB_UPLOAD_URL").Default("").String()
+	gitHubCAFile                = kingpin.Flag("gh.ca-file", "File with PEM encoded CA certificates trusted for the GitHub API besides the system ones.").Envar("GITHUB_CA_FILE").Default("").String()
+	gitHubProxyURL              = kingpin.Flag("gh.proxy-url", "Proxy for the GitHub API requests. Defaults to the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.").Envar("GITHUB_PROXY_URL").Default("").String()
+	gitHubOrgs                  = kingpin.Flag("gh.github-org", "GitHub Organization. Can be repeated or a comma separated list.").Envar("GITHUB_ORG").Strings()
+	gitHubUsers                 = kingpin.Flag("gh.github-user", "GitHub User. Can be repeated or a comma separated list.").Envar("GITHUB_USER").Strings()
+	gitHubEnterprises           = kingpin.Flag("gh.github-enterprise", "Slug of a GitHub Enterprise. Can be repeated or a comma separated list.").Envar("GITHUB_ENTERPRISE").Strings()
+	gitHubEnterpriseToken       = kingpin.Flag("gh.github-enterprise-token", "GitHub personal access token with the manage_billing:enterprise scope used for the enterprises. Defaults to the GitHub API Token.").Envar("GITHUB_ENTERPRISE_TOKEN").Default("").String()
+	gitHubAPIRateLimitThreshold = kingpin.Flag("gh.api-rate-limit-threshold", "Remaining GitHub API rate limit of a token below which the API polling is paused until the rate limit is reset.").Envar("GITHUB_API_RATE_LIMIT_THRESHOLD").Default("100").Int()
+	gitHubAccountsFile          = kingpin.Flag("gh.github-accounts-file", "File with the GitHub Organizations, Users and Enterprises to poll, one <org|user|enterprise>:<login>[=<poll interval>] per line.").Envar("GITHUB_ACCOUNTS_FILE").Default("").String()
 	gitHubBillingPollingSeconds = kingpin.Flag("gh.billing-poll-seconds", "Frequency at which to poll billing API.").Envar("BILLING_POLL_SECONDS").Default("5").Int()
+	gitHubBillingUsageReport    = kingpin.Flag("gh.billing-usage-report", "Collect the Actions usage per repository and SKU from the usage report of the enhanced billing platform, falling back to the Actions billing endpoint for accounts without usage report.").Envar("BILLING_USAGE_REPORT").Default("false").Bool()
+	runnersPollInterval         = kingpin.Flag("gh.runners-poll-interval", "Frequency at which to poll the self-hosted runners of the GitHub Organizations, Enterprises and --gh.runners-repo repositories. 0 disables it.").Envar("RUNNERS_POLL_INTERVAL").Default("0").Duration()
+	runnerRepositories          = kingpin.Flag("gh.runners-repo", "Repository, as <owner>/<repo>, whose self-hosted runners are polled. Can be repeated.").Envar("RUNNERS_REPOS").Strings()
+	cachePollInterval           = kingpin.Flag("gh.cache-poll-interval", "Frequency at which to poll the actions cache usage of the GitHub Organizations and their repositories. 0 disables it.").Envar("CACHE_POLL_INTERVAL").Default("0").Duration()
+	cacheLargestKeys            = kingpin.Flag("gh.cache-largest-keys", "Number of the largest cache keys of each repository to export, up to 100. 0 disables it.").Envar("CACHE_LARGEST_KEYS").Default("0").Int()
+	reconcileInterval           = kingpin.Flag("gh.reconcile-interval", "Frequency at which the workflow runs and jobs of the --gh.reconcile-repo repositories are listed with the GitHub API to recover the completions whose delivery was missed. 0 disables it.").Envar("RECONCILE_INTERVAL").Default("0").Duration()
+	reconcileRepositories       = kingpin.Flag("gh.reconcile-repo", "Repository, as <owner>/<repo>, whose workflow runs and jobs are reconciled. Can be repeated.").Envar("RECONCILE_REPOS").Strings()
+	reconcileLookback           = kingpin.Flag("gh.reconcile-lookback", "How far back the reconciler looks for completed workflow runs, by their creation time.").Envar("RECONCILE_LOOKBACK").Default("1h").Duration()
+	journalDir                  = kingpin.Flag("journal.dir", "Directory of the journal recording the accepted webhook deliveries as JSONL files. Empty disables it.").Envar("JOURNAL_DIR").Default("").String()
+	journalMaxFileSize          = kingpin.Flag("journal.max-file-size", "Size at which a journal file is rotated.").Envar("JOURNAL_MAX_FILE_SIZE").Default("100MB").Bytes()
+	journalMaxFiles             = kingpin.Flag("journal.max-files", "Maximum number of journal files kept. 0 keeps them all.").Envar("JOURNAL_MAX_FILES").Default("10").Int()
+	journalRetention            = kingpin.Flag("journal.retention", "Time for which the journal files are kept. 0 keeps them forever.").Envar("JOURNAL_RETENTION").Default("168h").Duration()
+	journalCapture              = kingpin.Flag("journal.capture", "Record the rejected webhook deliveries in the journal too, along with the headers of every delivery.").Envar("JOURNAL_CAPTURE").Default("false").Bool()
+	stateStore                  = kingpin.Flag("state.store", "Store the deduplication keys and the tracked jobs are persisted to across restarts. Empty disables it.").Envar("STATE_STORE").Default("").Enum("", server.StateStoreFile)
+	statePath                   = kingpin.Flag("state.path", "Path of the state file of the file store.").Envar("STATE_PATH").Default("github_actions_exporter.state.json").String()
+	stateSaveInterval           = kingpin.Flag("state.save-interval", "Interval at which the state is saved, besides on shutdown. 0 saves it on shutdown only.").Envar("STATE_SAVE_INTERVAL").Default("1m").Duration()
+	stateCounters               = kingpin.Flag("state.counters", "Persist the values of the workflow counters along with the state.").Envar("STATE_COUNTERS").Default("false").Bool()
+	haBackend                   = kingpin.Flag("ha.backend", "Backend the replicas share their deduplication and job lifecycle state through. Empty disables the HA mode.").Envar("HA_BACKEND").Default("").Enum("", server.HABackendRedis)
+	haRedisURL                  = kingpin.Flag("ha.redis-url", "URL of the Redis protocol server of the redis backend, as redis://[:password@]host:port/db.").Envar("HA_REDIS_URL").Default("redis://localhost:6379/0").String()
+	haKeyPrefix                 = kingpin.Flag("ha.key-prefix", "Prefix of the keys of the shared state.").Envar("HA_KEY_PREFIX").Default("github_actions_exporter:").String()
+	haLeaderElection            = kingpin.Flag("ha.leader-election", "Elect a leader among the replicas, the only one polling the GitHub API.").Envar("HA_LEADER_ELECTION").Default("false").Bool()
+	haLeaseDuration             = kingpin.Flag("ha.lease-duration", "Duration of the lease of the leader, after which another replica takes over when the leader is gone.").Envar("HA_LEASE_DURATION").Default("15s").Duration()
+	haIdentity                  = kingpin.Flag("ha.identity", "Identity of the replica in the leader election. Defaults to the hostname.").Envar("HA_IDENTITY").Default("").String()
 )
 
 func init() {
@@ -39,31 +95,114 @@ func main() {
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version.Print("ghactions_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
+	switch command {
+	case backfillCommand.FullCommand():
+		os.Exit(runBackfill(logger))
+	case replayCommand.FullCommand():
+		os.Exit(runReplay(logger))
+	case sendEventCommand.FullCommand():
+		os.Exit(runSendEvent(logger))
+	}
+
 	_ = level.Info(logger).Log("msg", "Starting ghactions_exporter", "version", version.Info())
 	_ = level.Info(logger).Log("build_context", version.BuildContext())
 
-	if err := validateFlags(*githubWebhookToken); err != nil {
+	additionalWebhookTokens, err := loadWebhookTokens(*githubWebhookExtraTokens, *githubWebhookTokenFile)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to load the GitHub Webhook Tokens", "err", err)
+		os.Exit(1)
+	}
+
+	targetWebhookTokens, targetOwners, err := loadWebhookTargetTokens(*githubWebhookTargetTokens, *githubWebhookTargetFile)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to load the GitHub Webhook Tokens per target", "err", err)
+		os.Exit(1)
+	}
+
+	if err := validateFlags(*githubWebhookToken, additionalWebhookTokens, targetWebhookTokens); err != nil {
 		_ = level.Error(logger).Log("msg", "Missing configure flags", "err", err)
 		os.Exit(1)
 	}
 
+	gitHubAccounts, err := loadGitHubAccounts(*gitHubOrgs, *gitHubUsers, *gitHubEnterprises, *gitHubAccountsFile)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to load the GitHub Organizations, Users and Enterprises", "err", err)
+		os.Exit(1)
+	}
+
+	gitHubAppPrivateKey, gitHubCACertificates, err := readGitHubAPIFiles()
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to read the GitHub API configuration", "err", err)
+		os.Exit(1)
+	}
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-	srv := server.NewServer(logger, server.Opts{
-		WebhookPath:           *ghWebHookPath,
-		ListenAddressMetrics:  *listenAddressMetrics,
-		ListenAddressIngress:  *listenAddressIngress,
-		MetricsPath:           *metricsPath,
-		GitHubToken:           *githubWebhookToken,
-		GitHubAPIToken:        *gitHubAPIToken,
-		GitHubUser:            *gitHubUser,
-		GitHubOrg:             *gitHubOrg,
-		BillingAPIPollSeconds: *gitHubBillingPollingSeconds,
+	srv, err := server.NewServer(logger, server.Opts{
+		WebhookPath:                 *ghWebHookPath,
+		ListenAddressMetrics:        *listenAddressMetrics,
+		ListenAddressIngress:        *listenAddressIngress,
+		MetricsPath:                 *metricsPath,
+		GitHubToken:                 *githubWebhookToken,
+		AdditionalGitHubTokens:      additionalWebhookTokens,
+		TargetGitHubTokens:          targetWebhookTokens,
+		TargetOwners:                targetOwners,
+		TargetFallbackGlobalTokens:  *githubWebhookTargetFallback,
+		AllowSHA1Signature:          *githubWebhookAllowSHA1,
+		DeduplicationWindow:         *webhookDedupWindow,
+		DeduplicationMaxEntries:     *webhookDedupMaxEntries,
+		DeduplicateJobActions:       *webhookDedupJobActions,
+		InFlightJobTTL:              *inFlightJobTTL,
+		WorkflowJobRunnerLabels:     *jobRunnerLabels,
+		WorkflowJobRunnerName:       *jobRunnerName,
+		StepNames:                   *stepNames,
+		StepNameRegex:               *stepNameRegex,
+		EventWorkers:                *eventWorkers,
+		EventQueueSize:              *eventQueueSize,
+		GitHubAPIToken:              *gitHubAPIToken,
+		GitHubAppID:                 *gitHubAppID,
+		GitHubAppPrivateKey:         gitHubAppPrivateKey,
+		GitHubAppInstallationID:     *gitHubAppInstallationID,
+		GitHubAPIURL:                *gitHubAPIURL,
+		GitHubUploadURL:             *gitHubUploadURL,
+		GitHubCACertificates:        gitHubCACertificates,
+		GitHubProxyURL:              *gitHubProxyURL,
+		GitHubEnterpriseToken:       *gitHubEnterpriseToken,
+		GitHubAPIRateLimitThreshold: *gitHubAPIRateLimitThreshold,
+		GitHubAccounts:              gitHubAccounts,
+		BillingAPIPollSeconds:       *gitHubBillingPollingSeconds,
+		BillingUsageReport:          *gitHubBillingUsageReport,
+		RunnersPollInterval:         *runnersPollInterval,
+		RunnerRepositories:          *runnerRepositories,
+		CachePollInterval:           *cachePollInterval,
+		CacheLargestKeys:            *cacheLargestKeys,
+		ReconcileInterval:           *reconcileInterval,
+		ReconcileRepositories:       *reconcileRepositories,
+		ReconcileLookback:           *reconcileLookback,
+		JournalDir:                  *journalDir,
+		JournalMaxFileSize:          int64(*journalMaxFileSize),
+		JournalMaxFiles:             *journalMaxFiles,
+		JournalRetention:            *journalRetention,
+		JournalCapture:              *journalCapture,
+		StateStore:                  *stateStore,
+		StatePath:                   *statePath,
+		StateSaveInterval:           *stateSaveInterval,
+		StateCounters:               *stateCounters,
+		HABackend:                   *haBackend,
+		HARedisURL:                  *haRedisURL,
+		HAKeyPrefix:                 *haKeyPrefix,
+		HALeaderElection:            *haLeaderElection,
+		HALeaseDuration:             *haLeaseDuration,
+		HAIdentity:                  *haIdentity,
 	})
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to create the server", "err", err)
+		os.Exit(1)
+	}
 	go func() {
 		err := srv.Serve(context.Background())
 		if err != nil {
@@ -74,7 +213,9 @@ func main() {
 	}()
 
 	_ = level.Info(logger).Log("msg", fmt.Sprintf("Signal received: %v. Exiting...", <-signalChan))
-	err := srv.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	err = srv.Shutdown(ctx)
+	cancel()
 	if err != nil {
 		_ = level.Error(logger).Log("msg", "Error occurred while closing the server", "err", err)
 		os.Exit(1)
@@ -82,9 +223,201 @@ func main() {
 	os.Exit(0)
 }
 
-func validateFlags(token string) error {
-	if token == "" {
+// readGitHubAPIFiles reads the GitHub App private key and the CA certificates trusted for the
+// GitHub API, when configured.
+func readGitHubAPIFiles() (privateKey, caCertificates []byte, err error) {
+	if *gitHubAppPrivateKeyFile != "" {
+		privateKey, err = os.ReadFile(*gitHubAppPrivateKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading the GitHub App private key: %w", err)
+		}
+	}
+	if *gitHubCAFile != "" {
+		caCertificates, err = os.ReadFile(*gitHubCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading the GitHub CA certificates: %w", err)
+		}
+	}
+	return privateKey, caCertificates, nil
+}
+
+func validateFlags(token string, additionalTokens []string, targetTokens map[string][]string) error {
+	if token == "" && len(additionalTokens) == 0 && len(targetTokens) == 0 {
 		return errors.New("please configure the GitHub Webhook Token")
 	}
 	return nil
 }
+
+// webhookSecretOpts returns the options with the webhook secrets and delivery targets configured
+// by the flags, with which the replay and send-test-event commands sign the deliveries.
+func webhookSecretOpts() (server.Opts, error) {
+	additionalWebhookTokens, err := loadWebhookTokens(*githubWebhookExtraTokens, *githubWebhookTokenFile)
+	if err != nil {
+		return server.Opts{}, err
+	}
+	targetWebhookTokens, targetOwners, err := loadWebhookTargetTokens(*githubWebhookTargetTokens, *githubWebhookTargetFile)
+	if err != nil {
+		return server.Opts{}, err
+	}
+	return server.Opts{
+		GitHubToken:                *githubWebhookToken,
+		AdditionalGitHubTokens:     additionalWebhookTokens,
+		TargetGitHubTokens:         targetWebhookTokens,
+		TargetOwners:               targetOwners,
+		TargetFallbackGlobalTokens: *githubWebhookTargetFallback,
+	}, nil
+}
+
+// loadWebhookTokens merges the tokens given on the command line with the ones read from
+// tokenFile. Empty lines and lines starting with # are ignored.
+func loadWebhookTokens(tokens []string, tokenFile string) ([]string, error) {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			result = append(result, token)
+		}
+	}
+
+	if tokenFile == "" {
+		return result, nil
+	}
+
+	lines, err := readListFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(result, lines...), nil
+}
+
+// loadWebhookTargetTokens parses the <kind>:<value>=<token> entries given on the command line
+// and in tokenFile into the tokens accepted per delivery target, and the owners each hook and
+// installation target is bound to, given as <kind>:<id>@<owner>[,<owner>...].
+func loadWebhookTargetTokens(entries []string, tokenFile string) (map[string][]string, map[string][]string, error) {
+	if tokenFile != "" {
+		lines, err := readListFile(tokenFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, lines...)
+	}
+
+	tokens := map[string][]string{}
+	owners := map[string][]string{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, token, found := strings.Cut(entry, "=")
+		kind, value, hasValue := strings.Cut(target, ":")
+		if !found || !hasValue || token == "" {
+			return nil, nil, fmt.Errorf("invalid webhook target token %q, expected <kind>:<value>=<token>", target)
+		}
+		value, boundOwners, bound := strings.Cut(value, "@")
+		key, err := server.WebhookTargetKey(kind, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The hook and installation target headers are not signed, the owners their deliveries
+		// may be about are configured along with the token.
+		if bound != (kind != server.WebhookTargetOwner) || (bound && boundOwners == "") {
+			return nil, nil, fmt.Errorf("invalid webhook target %q, expected <hook|installation-target>:<id>@<owner>[,<owner>...] or owner:<login>", target)
+		}
+		tokens[key] = append(tokens[key], token)
+		if !bound {
+			continue
+		}
+		for _, owner := range strings.Split(boundOwners, ",") {
+			if owner = strings.ToLower(strings.TrimSpace(owner)); owner != "" && !slices.Contains(owners[key], owner) {
+				owners[key] = append(owners[key], owner)
+			}
+		}
+	}
+
+	return tokens, owners, nil
+}
+
+// loadGitHubAccounts merges the organizations, users and enterprises given on the command line
+// with the <org|user|enterprise>:<login>[=<poll interval>] entries read from accountsFile.
+// Duplicates are ignored.
+func loadGitHubAccounts(orgs, users, enterprises []string, accountsFile string) ([]server.GitHubAccount, error) {
+	var entries []string
+	appendLogins := func(kind string, logins []string) {
+		for _, login := range logins {
+			for _, login := range strings.Split(login, ",") {
+				entries = append(entries, kind+":"+strings.TrimSpace(login))
+			}
+		}
+	}
+	appendLogins(server.GitHubAccountOrg, orgs)
+	appendLogins(server.GitHubAccountUser, users)
+	appendLogins(server.GitHubAccountEnterprise, enterprises)
+
+	if accountsFile != "" {
+		lines, err := readListFile(accountsFile)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, lines...)
+	}
+
+	var result []server.GitHubAccount
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		target, interval, hasInterval := strings.Cut(entry, "=")
+		kind, login, found := strings.Cut(strings.TrimSpace(target), ":")
+		if found && login == "" {
+			continue
+		}
+		switch kind {
+		case server.GitHubAccountOrg, server.GitHubAccountUser, server.GitHubAccountEnterprise:
+		default:
+			found = false
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid GitHub account %q, expected <org|user|enterprise>:<login>[=<poll interval>]", entry)
+		}
+
+		account := server.GitHubAccount{Kind: kind, Login: login}
+		if hasInterval {
+			pollInterval, err := time.ParseDuration(strings.TrimSpace(interval))
+			if err != nil {
+				return nil, fmt.Errorf("invalid poll interval of GitHub account %q: %w", target, err)
+			}
+			if pollInterval <= 0 {
+				return nil, fmt.Errorf("invalid poll interval of GitHub account %q: %s is not positive", target, pollInterval)
+			}
+			account.PollInterval = pollInterval
+		}
+
+		key := kind + ":" + strings.ToLower(login)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, account)
+	}
+
+	return result, nil
+}
+
+// readListFile returns the lines of a file, skipping empty lines and comments.
+func readListFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// CheckSuiteEventFromJSON decodes the incomming message to a github.CheckSuiteEvent
+func CheckSuiteEventFromJSON(data io.Reader) *github.CheckSuiteEvent {
+	decoder := json.NewDecoder(data)
+	var event github.CheckSuiteEvent
+	if err := decoder.Decode(&event); err != nil {
+		return nil
+	}
+
+	return &event
+}
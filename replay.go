@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+var (
+	replayCommand   = kingpin.Command("replay", "Replay captured webhook deliveries, in process printing the resulting metrics, or to a running exporter.")
+	replayPath      = replayCommand.Arg("deliveries", "JSONL file of captured deliveries, one per line, or directory of such files and of JSON files of one delivery each.").Required().ExistingFileOrDir()
+	replayTargetURL = replayCommand.Flag("target-url", "Webhook URL of a running exporter the deliveries are signed with the webhook secret of their delivery target and posted to, instead of replaying them in process.").String()
+	replayOutput    = replayCommand.Flag("output", "File the metrics replayed in process are written to, - for the standard output.").Short('o').Default("-").String()
+)
+
+// runReplay runs the replay command and returns the exit code.
+func runReplay(logger log.Logger) int {
+	deliveries, err := server.ReadDeliveries(*replayPath)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to read the deliveries", "err", err)
+		return 1
+	}
+
+	opts, err := webhookSecretOpts()
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to load the GitHub Webhook Tokens", "err", err)
+		return 1
+	}
+	opts.DeduplicationWindow = *webhookDedupWindow
+	opts.DeduplicationMaxEntries = *webhookDedupMaxEntries
+	opts.DeduplicateJobActions = *webhookDedupJobActions
+	opts.InFlightJobTTL = *inFlightJobTTL
+	opts.WorkflowJobRunnerLabels = *jobRunnerLabels
+	opts.WorkflowJobRunnerName = *jobRunnerName
+	opts.StepNames = *stepNames
+	opts.StepNameRegex = *stepNameRegex
+	replayer := server.NewReplayer(logger, opts)
+
+	var rejected int
+	if *replayTargetURL != "" {
+		rejected, err = replayer.ReplayTo(context.Background(), http.DefaultClient, *replayTargetURL, deliveries)
+	} else {
+		var w io.Writer = os.Stdout
+		if *replayOutput != "-" {
+			file, err := os.Create(*replayOutput)
+			if err != nil {
+				_ = level.Error(logger).Log("msg", "Unable to create the output file", "err", err)
+				return 1
+			}
+			defer file.Close()
+			w = file
+		}
+		rejected, err = replayer.ReplayInProcess(context.Background(), w, deliveries)
+	}
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to replay the deliveries", "err", err)
+		return 1
+	}
+
+	_ = level.Info(logger).Log("msg", "Replayed the deliveries", "deliveries", len(deliveries), "rejected", rejected)
+	if rejected > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/cpanato/github_actions_exporter/internal/server"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+var (
+	sendEventCommand       = kingpin.Command("send-test-event", "Sign synthetic webhook deliveries with the webhook secret of their delivery target and post them to a running exporter, reporting the status of the responses.")
+	sendEventTargetURL     = sendEventCommand.Flag("target-url", "Webhook URL of the exporter the deliveries are posted to.").Required().String()
+	sendEventEvents        = sendEventCommand.Flag("event", "Event sent, as a ping or as the lifecycle of a workflow job or run. Can be repeated.").Default(server.SyntheticEventPing, server.SyntheticEventWorkflowJob, server.SyntheticEventWorkflowRun).Enums(server.SyntheticEventPing, server.SyntheticEventWorkflowJob, server.SyntheticEventWorkflowRun)
+	sendEventRepository    = sendEventCommand.Flag("repo", "Repository, as <owner>/<repo>, of the synthetic workflow.").Default("github-actions-exporter/test-event").String()
+	sendEventBranch        = sendEventCommand.Flag("branch", "Branch of the synthetic workflow.").Default("main").String()
+	sendEventWorkflowName  = sendEventCommand.Flag("workflow-name", "Name of the synthetic workflow.").Default("Test event").String()
+	sendEventJobName       = sendEventCommand.Flag("job-name", "Name of the synthetic job.").Default("test").String()
+	sendEventRunnerName    = sendEventCommand.Flag("runner-name", "Name of the runner of the synthetic job.").Default("test-runner").String()
+	sendEventRunnerLabels  = sendEventCommand.Flag("runner-label", "Label of the runner of the synthetic job. Can be repeated.").Default("self-hosted").Strings()
+	sendEventConclusion    = sendEventCommand.Flag("conclusion", "Conclusion of the synthetic workflow.").Default("success").Enum("success", "failure", "cancelled", "skipped", "timed_out")
+	sendEventQueueDuration = sendEventCommand.Flag("queue-duration", "Time the synthetic job waits in the queue.").Default("10s").Duration()
+	sendEventDuration      = sendEventCommand.Flag("duration", "Time the synthetic job and workflow run take once started.").Default("1m").Duration()
+	sendEventTimeout       = sendEventCommand.Flag("timeout", "Timeout of each request.").Default("10s").Duration()
+	sendEventTarget        = sendEventCommand.Flag("target", "Delivery target, as <hook|installation-target>:<id>, the deliveries are sent as, so that they are signed with its webhook secret.").String()
+)
+
+// runSendEvent runs the send-test-event command and returns the exit code.
+func runSendEvent(logger log.Logger) int {
+	owner, repo, ok := strings.Cut(*sendEventRepository, "/")
+	if !ok || owner == "" || repo == "" {
+		_ = level.Error(logger).Log("msg", "Invalid --repo", "err", fmt.Errorf("%q is not <owner>/<repo>", *sendEventRepository))
+		return 1
+	}
+	opts := server.SyntheticEventOpts{
+		Owner:         owner,
+		Repo:          repo,
+		Branch:        *sendEventBranch,
+		WorkflowName:  *sendEventWorkflowName,
+		JobName:       *sendEventJobName,
+		RunnerName:    *sendEventRunnerName,
+		RunnerLabels:  *sendEventRunnerLabels,
+		Conclusion:    *sendEventConclusion,
+		QueueDuration: *sendEventQueueDuration,
+		Duration:      *sendEventDuration,
+	}
+
+	headers, err := targetHeaders(*sendEventTarget)
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Invalid --target", "err", err)
+		return 1
+	}
+	replayOpts, err := webhookSecretOpts()
+	if err != nil {
+		_ = level.Error(logger).Log("msg", "Unable to load the GitHub Webhook Tokens", "err", err)
+		return 1
+	}
+
+	replayer := server.NewReplayer(logger, replayOpts)
+	client := &http.Client{Timeout: *sendEventTimeout}
+	var failed int
+	for _, event := range *sendEventEvents {
+		deliveries, err := server.SyntheticDeliveries(event, opts, time.Now())
+		if err != nil {
+			_ = level.Error(logger).Log("msg", "Unable to build the deliveries", "event", event, "err", err)
+			return 1
+		}
+		for _, delivery := range deliveries {
+			delivery.Headers = headers
+			status, err := replayer.Send(context.Background(), client, *sendEventTargetURL, delivery)
+			if err != nil {
+				_ = level.Error(logger).Log("msg", "Unable to send the delivery", "event", event, "deliveryID", delivery.ID, "err", err)
+				return 1
+			}
+			keyvals := []interface{}{"event", event, "deliveryID", delivery.ID, "status", status, "statusText", http.StatusText(status)}
+			if status < 200 || status >= 300 {
+				failed++
+				_ = level.Error(logger).Log(append([]interface{}{"msg", "Delivery rejected"}, keyvals...)...)
+				continue
+			}
+			_ = level.Info(logger).Log(append([]interface{}{"msg", "Delivery accepted"}, keyvals...)...)
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// targetHeaders returns the headers GitHub identifies the delivery target with, for a target
+// given as <hook|installation-target>:<id>.
+func targetHeaders(target string) (map[string]string, error) {
+	if target == "" {
+		return nil, nil
+	}
+	kind, id, _ := strings.Cut(target, ":")
+	if _, err := server.WebhookTargetKey(kind, id); err != nil {
+		return nil, err
+	}
+	switch kind {
+	case server.WebhookTargetHook:
+		return map[string]string{"X-GitHub-Hook-ID": id}, nil
+	case server.WebhookTargetInstallationTarget:
+		return map[string]string{"X-GitHub-Hook-Installation-Target-ID": id}, nil
+	default:
+		return nil, fmt.Errorf("%q is not a hook or an installation target, the owner is taken from the repository", target)
+	}
+}